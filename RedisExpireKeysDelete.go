@@ -2,216 +2,1699 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
-	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
+// 是否输出调试日志
+var debugLogging bool
+
+// 写入和读取过期键文件时是否使用 flock 加锁
+var fileLocking = true
+
+// 键名的最大长度 (字节)，超出的键被跳过，0 表示不限制
+var maxKeyLen int
+
 func main() {
-	// 定义命令行参数
-	addr := flag.String("addr", "localhost:6379", "Redis server address")
-	password := flag.String("password", "", "Redis password (if any)")
-	db := flag.Int("db", 0, "Redis database number")
-	interval := flag.Int("interval", 300, "Delete Interval")
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "migrate-file" {
+		if err := runMigrateFile(os.Args[2:]); err != nil {
+			log.Fatalf("migrate-file: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		if err := runStatus(os.Args[2:]); err != nil {
+			log.Fatalf("status: %v", err)
+		}
+		return
+	}
+
+	// 解析命令行参数
+	cfg := parseFlags()
+	if cfg.ShowVersion {
+		printVersion()
+		return
+	}
+	debugLogging = cfg.Debug
+	humanReadableStats = cfg.HumanReadableStats
+	if err := loadConfigFile(cfg); err != nil {
+		log.Fatalf("Failed to load --config: %v", err)
+	}
+	fileLocking = !cfg.NoFlock
+	maxKeyLen = cfg.MaxKeyLen
+	suppressDuplicateLogs = cfg.SuppressDuplicateLogs
+	if _, err := newKeySet(cfg.KeyDedupAlgorithm, 0); err != nil {
+		log.Fatalf("Invalid --key-dedup-algorithm: %v", err)
+	}
+	keyDedupAlgorithm = cfg.KeyDedupAlgorithm
+	if cfg.KeyFileDir != "" {
+		// 挂载错误的卷在收到第一个过期事件之前就能发现
+		if err := checkDirWritable(cfg.KeyFileDir); err != nil {
+			log.Fatalf("--key-file-dir %s is not writable, check the volume mount: %v", cfg.KeyFileDir, err)
+		}
+		cfg.KeyFile = filepath.Join(cfg.KeyFileDir, "expired_keys")
+	}
+	var memoryLimit int64
+	if cfg.MemoryLimitMB > 0 {
+		memoryLimit = setMemoryLimit(cfg.MemoryLimitMB)
+	}
+	var urlOpts *redis.Options
+	if cfg.ConnectionString != "" {
+		var err error
+		if urlOpts, err = parseConnectionString(cfg); err != nil {
+			log.Fatalf("Invalid --connection-string: %v", err)
+		}
+	}
+	logStartupConfig(cfg)
+	if _, err := nextOccurrence(time.Now(), cfg.OnceAt); err != nil {
+		log.Fatalf("Invalid --once-at: %v", err)
+	}
+	if err := validateChannelPattern(cfg.ChannelPattern); err != nil {
+		log.Fatalf("Invalid --channel-pattern: %v", err)
+	}
+
+	// 已知 Redis 启动较慢时 (例如 Docker Compose 中没有健康检查依赖)，先固定等待一段时间再连接
+	if cfg.StartupDelay > 0 {
+		log.Printf("Waiting %v before connecting to Redis (startup-delay)", cfg.StartupDelay)
+		time.Sleep(cfg.StartupDelay)
+	}
+
+	// 创建 Redis 客户端
+	opts := &redis.Options{
+		Addr:     cfg.Addr,        // Redis 地址
+		Username: cfg.ACLUsername, // Redis 6+ ACL 用户名，为空时使用 default 用户
+		Password: cfg.Password,    // Redis 密码
+		DB:       cfg.DB,          // Redis 数据库
+	}
+	if urlOpts != nil {
+		opts = urlOpts
+	}
+	rdb := redis.NewClient(opts)
+
+	// 清理失败且开启 --exit-on-cleanup-error 时以退出码 1 退出，
+	// 最先注册，在其他 defer (关闭审计日志等) 执行完之后才退出
+	var cleanupFailed atomic.Bool
+	defer func() {
+		if cleanupFailed.Load() {
+			os.Exit(1)
+		}
+	}()
+
+	// 收到 SIGINT / SIGTERM 时取消 ctx，让各个 goroutine 尽快退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.TraceFile != "" {
+		shutdown, err := setupTracing(cfg.TraceFile, cfg.TraceSampleRate)
+		if err != nil {
+			log.Fatalf("Failed to set up tracing: %v", err)
+		}
+		// 退出前写出缓冲中的 span
+		defer shutdown(context.Background())
+	}
+
+	// 容器编排中 Redis 可能晚于本工具启动，先等待 Redis 可用
+	if err := waitForRedis(ctx, rdb, cfg.StartupRetries, &BackoffPolicy{Initial: cfg.StartupInitialBackoff, Max: cfg.MaxReconnectInterval}); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	// 使用 ACL 用户时确认该用户有本工具需要的命令权限
+	if cfg.ACLUsername != "" {
+		checkACLPermissions(ctx, rdb, requiredCommands(cfg))
+	}
+
+	// 存储过期键的文件
+	if cfg.Format != formatText && cfg.Format != formatJSON && cfg.Format != formatBinaryLog {
+		log.Fatalf("Unknown key file format %q (expected text, json or binary-log)", cfg.Format)
+	}
+	if cfg.KeyFileEncrypt {
+		keyCipher, err := newRecordCipher(cfg, cfg.KeyFile+".salt")
+		if err != nil {
+			log.Fatalf("Failed to set up --key-file-encrypt: %v", err)
+		}
+		keyFileCipher.Store(keyCipher)
+	}
+	store := NewFileKeyStore(cfg.KeyFile, cfg.Format)
+	var dbStore *DBKeyStore
+	var dbCount int
+	if cfg.PerDBFiles {
+		dbCount = detectDBCount(ctx, rdb, cfg.RedisDBCount)
+		dbStore = NewDBKeyStore(cfg.KeyFile, cfg.Format, dbCount)
+	}
+	if cfg.MaxFileLines > 0 {
+		var err error
+		if dbStore != nil {
+			err = dbStore.SetLineLimit(cfg.MaxFileLines, cfg.OverflowPolicy)
+		} else {
+			err = store.SetLineLimit(cfg.MaxFileLines, cfg.OverflowPolicy)
+		}
+		if err != nil {
+			log.Fatalf("Failed to set --max-file-lines: %v", err)
+		}
+	}
+	if cfg.LargeBacklogAlert {
+		if cfg.AlertWebhookURL == "" {
+			log.Fatalf("--alert-webhook-on-large-backlog requires --alert-webhook-url")
+		}
+		alerter := newBacklogAlerter(cfg.AlertWebhookURL, cfg.LargeBacklogThreshold, cfg.AlertCooldown)
+		var err error
+		if dbStore != nil {
+			err = dbStore.SetBacklogAlert(alerter)
+		} else {
+			err = store.SetBacklogAlert(alerter)
+		}
+		if err != nil {
+			log.Fatalf("Failed to set --alert-webhook-on-large-backlog: %v", err)
+		}
+	}
+	if cfg.TrackReSets {
+		var err error
+		if dbStore != nil {
+			err = dbStore.TrackKeys()
+		} else {
+			err = store.TrackKeys()
+		}
+		if err != nil {
+			log.Fatalf("Failed to index key file for --track-re-sets: %v", err)
+		}
+	}
+	if cfg.KeyFileChecksum {
+		var err error
+		if dbStore != nil {
+			err = dbStore.EnableChecksum()
+		} else {
+			err = store.EnableChecksum()
+		}
+		if err != nil {
+			log.Fatalf("Key file is corrupted, manual intervention required: %v", err)
+		}
+	}
+	// 收到 --key-rotation-signal 时轮换加密密钥，失败时继续使用旧密钥
+	if cfg.KeyRotationSignal != "" {
+		if !cfg.KeyFileEncrypt || cfg.NewEncryptionKeyEnv == "" {
+			log.Fatalf("--key-rotation-signal requires --key-file-encrypt and --new-encryption-key-env")
+		}
+		sig, err := parseRotationSignal(cfg.KeyRotationSignal)
+		if err != nil {
+			log.Fatalf("Invalid --key-rotation-signal: %v", err)
+		}
+		stores := []*FileKeyStore{store}
+		if dbStore != nil {
+			stores = dbStore.Stores()
+		}
+		var errorsFiles []string
+		if cfg.ErrorsFile != "" && cfg.PerDBFiles {
+			for n := 0; n < dbCount; n++ {
+				errorsFiles = append(errorsFiles, fmt.Sprintf("%s.%d", cfg.ErrorsFile, n))
+			}
+		} else if cfg.ErrorsFile != "" {
+			errorsFiles = []string{cfg.ErrorsFile}
+		}
+		rotate := make(chan os.Signal, 1)
+		signal.Notify(rotate, sig)
+		go func() {
+			for range rotate {
+				if err := rotateEncryptionKey(stores, errorsFiles, cfg.NewEncryptionKeyEnv, cfg.KeyFile+".salt"); err != nil {
+					log.Printf("Key file encryption key rotation failed: %v", err)
+				}
+			}
+		}()
+	}
+	if cfg.MetaHashPrefix != "" && cfg.Format != formatJSON {
+		log.Println("--meta-hash-prefix only takes effect with --format=json")
+	}
+	if cfg.NoBackup {
+		log.Println("WARN: --no-backup is set: keys read for a cleanup are lost if the process crashes before it finishes")
+	}
+	if cfg.KeyAgeThreshold > 0 && cfg.Format != formatJSON {
+		log.Println("--key-age-threshold only takes effect with --format=json")
+	}
+	switch cfg.KeyFileCompression {
+	case compressionNone:
+		if cfg.CompressBackup {
+			cfg.KeyFileCompression = compressionGzip
+		}
+	case compressionGzip, compressionZstd:
+	default:
+		log.Fatalf("Unknown --key-file-compression %q (expected none, gzip or zstd)", cfg.KeyFileCompression)
+	}
+	if cfg.KeyGroupBy != "" && cfg.KeyGroupBy != keyGroupByPrefix {
+		log.Fatalf("Unknown --key-group-by %q (expected prefix)", cfg.KeyGroupBy)
+	}
+	if cfg.KeyGroupBy != "" && (cfg.TransactionBatch || cfg.ParallelCleanupShards > 1) {
+		log.Println("--key-group-by only takes effect without --transaction-batch and --parallel-cleanup-shards")
+	}
+
+	// 打开审计日志，收到 SIGHUP 时重新打开以配合外部日志轮转
+	var audit *AuditLog
+	if cfg.AuditLog != "" {
+		var err error
+		audit, err = OpenAuditLog(cfg.AuditLog)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer audit.Close()
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := audit.Reopen(); err != nil {
+					log.Printf("Failed to reopen audit log: %v", err)
+				} else {
+					log.Println("Reopened audit log")
+				}
+			}
+		}()
+	}
+
+	// 黑名单 / 白名单，收到 SIGHUP 或开启 --watch-filter-files 时自动重新读取
+	var filter *keyFilter
+	if cfg.BlacklistFile != "" || cfg.WhitelistFile != "" {
+		var err error
+		filter, err = newKeyFilter(cfg.BlacklistFile, cfg.WhitelistFile)
+		if err != nil {
+			log.Fatalf("Failed to load filter files: %v", err)
+		}
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := filter.Reload(); err != nil {
+					log.Printf("Failed to reload filter files: %v", err)
+				} else {
+					log.Println("Reloaded filter files")
+				}
+			}
+		}()
+
+		if cfg.WatchFilterFiles {
+			go func() {
+				if err := filter.Watch(ctx); err != nil {
+					log.Printf("Failed to watch filter files: %v", err)
+				}
+			}()
+		}
+	}
+
+	// 向 HTTP / gRPC 实时订阅者广播过期事件
+	var broker *eventBroker
+	if cfg.HTTPAddr != "" || cfg.GRPCAddr != "" {
+		broker = newEventBroker()
+	}
+
+	// 导出指标，Prometheus 和 StatsD 可以同时启用
+	var backends []MetricBackend
+	if cfg.HTTPAddr != "" {
+		backends = append(backends, newPrometheusBackend(prometheus.WrapRegistererWith(cfg.Tags, prometheus.DefaultRegisterer)))
+		serveHTTP(cfg.HTTPAddr, broker, splitPatterns(cfg.AllowedOrigins))
+	}
+	if cfg.StatsdAddr != "" {
+		statsdBackend, err := newStatsdBackend(cfg.StatsdAddr, cfg.StatsdPrefix, cfg.Tags)
+		if err != nil {
+			log.Fatalf("Failed to create StatsD client: %v", err)
+		}
+		defer statsdBackend.Close()
+		backends = append(backends, statsdBackend)
+	}
+	metrics := NewMetrics(backends...)
+	if suppressDuplicateLogs {
+		go runDuplicateLogFlusher(ctx)
+	}
+	if memoryLimit > 0 {
+		go monitorMemory(ctx, memoryLimit, metrics)
+	}
+
+	// 处理过期事件
+	handler := &eventHandler{cfg: cfg, rdb: rdb, store: store, dbStore: dbStore, metrics: metrics, broker: broker, filter: filter}
+	if cfg.StreamSink {
+		if cfg.UseStream && cfg.StreamSinkKey == cfg.StreamKey {
+			log.Fatalf("--stream-sink-key must differ from --stream-key, otherwise events are read back in a loop")
+		}
+		handler.sinks = append(handler.sinks, &RedisStreamSink{rdb: rdb, stream: cfg.StreamSinkKey, maxLen: cfg.StreamSinkMaxLen})
+	}
+	if cfg.ExportBeforeExpiry {
+		exporter, err := newKeyExporter(rdb, cfg.DB, cfg.ExportThreshold, cfg.ExportDir)
+		if err != nil {
+			log.Fatalf("Failed to create --export-dir: %v", err)
+		}
+		handler.exporter = exporter
+	}
+	if cfg.KeyTTLBucket {
+		buckets, err := parseTTLBuckets(cfg.TTLBuckets)
+		if err != nil {
+			log.Fatalf("Invalid --ttl-buckets: %v", err)
+		}
+		metricBuckets[metricKeyTTLBucket] = buckets
+		handler.ttls = newTTLBucketer(metrics)
+	}
+	if cfg.MaxEventsPerSecond > 0 {
+		handler.limiter = rate.NewLimiter(rate.Limit(cfg.MaxEventsPerSecond), cfg.MaxEventsPerSecond)
+	}
+	if cfg.DedupOnWrite {
+		switch cfg.DedupBackend {
+		case dedupBackendMemory:
+			handler.dedup = newDedupCache(cfg.DedupWindow, cfg.DedupCacheSize)
+		case dedupBackendRedis:
+			handler.dedup = newRedisDedup(rdb, cfg.DedupPrefix, cfg.DedupWindow, cfg.DB)
+		default:
+			log.Fatalf("Unknown dedup backend %q (expected memory or redis)", cfg.DedupBackend)
+		}
+	}
+	if cfg.GRPCAddr != "" {
+		serveGRPC(cfg.GRPCAddr, broker)
+	}
+	if cfg.Analyze {
+		handler.analyzer = NewKeyAnalyzer(cfg.NamespaceSeparator)
+		go handler.analyzer.Run(ctx, cfg.AnalyzeWindow)
+	}
+	var prefixes *prefixStats
+	if cfg.KeyPrefixStats {
+		prefixes = newPrefixStats(cfg.NamespaceSeparator, metrics)
+		handler.prefixes = prefixes
+		if cfg.StatsInterval > 0 {
+			go prefixes.Run(ctx, cfg.StatsInterval)
+		}
+	}
+
+	var keyStats *KeyStatsFile
+	if cfg.KeyStatsFile != "" {
+		keyStats = NewKeyStatsFile(cfg.KeyStatsFile, cfg.KeyStatsRetention)
+	}
+
+	strategy, err := newDeletionStrategy(cfg.DeletionStrategy)
+	if err != nil {
+		log.Fatalf("Invalid --deletion-strategy: %v", err)
+	}
+	// --type-handler-config 中匹配的键只删除指定的字段
+	if cfg.TypeHandlerConfig != "" {
+		rules, err := loadTypeHandlers(cfg.TypeHandlerConfig)
+		if err != nil {
+			log.Fatalf("Failed to load --type-handler-config: %v", err)
+		}
+		strategy = &TypeAwareDeletion{rules: rules, fallback: strategy}
+	}
+	// 所有 Cleaner 共用的清理名额，--max-concurrent-cleanups 为 0 时不限制
+	var slots chan struct{}
+	if cfg.MaxConcurrentCleanups > 0 {
+		slots = make(chan struct{}, cfg.MaxConcurrentCleanups)
+	}
+	locker, err := newCleanupLocker(cfg, rdb)
+	if err != nil {
+		log.Fatalf("Invalid --lock-backend: %v", err)
+	}
+
+	// 每个 Cleaner 负责一个过期键文件，按数据库分文件时每个数据库各一个
+	cleaners := []*Cleaner{{rdb: rdb, store: store, cfg: cfg, audit: audit, keyStats: keyStats, metrics: metrics, strategy: strategy, locker: locker, slots: slots, filter: filter, prefixes: prefixes}}
+	if dbStore != nil {
+		cleaners = cleaners[:0]
+		for n := 0; n < dbCount; n++ {
+			dbOpts := *opts
+			dbOpts.DB = n
+			cleaners = append(cleaners, &Cleaner{rdb: redis.NewClient(&dbOpts), store: dbStore.Store(n), cfg: cfg, audit: audit, keyStats: keyStats, metrics: metrics, strategy: strategy, locker: locker, slots: slots, filter: filter, prefixes: prefixes})
+		}
+	}
+
+	if cfg.DeletionStrategy == strategyScript {
+		if err := loadConditionalDeleteScript(ctx, rdb); err != nil {
+			log.Fatalf("Failed to load conditional delete script: %v", err)
+		}
+	}
+
+	// 过期键写入 PostgreSQL 而不是文件
+	switch cfg.KeyStoreBackend {
+	case storeBackendFile:
+	case storeBackendPostgres:
+		if cfg.PostgresDSN == "" {
+			log.Fatalf("--key-store-backend=postgres requires --postgres-dsn")
+		}
+		pgStore, err := NewPostgresKeyStore(ctx, cfg.PostgresDSN)
+		if err != nil {
+			log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+		}
+		defer pgStore.Close()
+		go pgStore.Run(ctx)
+		handler.pgStore = pgStore
+		for _, cleaner := range cleaners {
+			cleaner.pg = pgStore
+		}
+	default:
+		log.Fatalf("Unknown --key-store-backend %q (expected file or postgres)", cfg.KeyStoreBackend)
+	}
+
+	// 在 --status-file 中记录清理状态，供 status 子命令读取
+	if cfg.StatusFile != "" {
+		status := NewStatusStore(cfg.StatusFile)
+		for _, cleaner := range cleaners {
+			cleaner.status = status
+		}
+		go status.Run(ctx, func() int64 {
+			var total int64
+			for _, cleaner := range cleaners {
+				if n, err := countLines(cleaner.store.Path(), cleaner.store.format); err == nil {
+					total += n
+				}
+			}
+			return total
+		})
+	}
+
+	// 订阅之前先扫描已经积压的过期键，写入过期键文件
+	if cfg.StartupScan {
+		for _, cleaner := range cleaners {
+			if err := cleaner.startupScan(ctx); err != nil && ctx.Err() == nil {
+				log.Fatalf("Startup scan failed: %v", err)
+			}
+		}
+	}
+
+	// 先处理上次运行遗留在文件中的过期键，再开始订阅
+	if cfg.RotateOnStartup {
+		for _, cleaner := range cleaners {
+			if err := cleaner.startupCleanup(ctx); err != nil {
+				cleanupFailed.Store(true)
+				return
+			}
+		}
+	}
+
+	// 只清理模式下不订阅过期事件，也不修改 Redis 配置
+	var collector EventCollector
+	if cfg.NoSubscribe {
+		log.Println("Cleanup-only mode: not subscribing to expired key events")
+	} else {
+		collector = newEventCollector(ctx, rdb, cfg, metrics)
+	}
+
+	if collector != nil {
+		// 启动一个 goroutine 来收集过期事件
+		events := make(chan ExpiredEvent, 100)
+		go func() {
+			defer close(events)
+			backoff := &BackoffPolicy{Initial: time.Second, Max: cfg.MaxReconnectInterval}
+			for {
+				started := time.Now()
+				err := collector.Collect(ctx, events)
+				if err == nil || ctx.Err() != nil {
+					return
+				}
+				// 连接保持时间超过退避上限，视为上次重连成功，重新从 1s 开始退避
+				if time.Since(started) > cfg.MaxReconnectInterval {
+					backoff.Reset()
+				}
+				// 连接失败和超时时重新开始收集，其他错误直接退出
+				category := classifyError(err)
+				if !category.retryable() {
+					log.Fatalf("Failed to collect expired events (%v): %v", category, err)
+				}
+				delay := backoff.NextDelay()
+				log.Printf("WARN: Collecting expired events failed (%v): %v, retrying in %v", category, err, delay)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		// 启动 --concurrent-subscriptions 个 goroutine 来处理过期事件
+		if cfg.TestExpiry {
+			handler.probe = make(chan struct{}, 1)
+		}
+		pc, isPubsub := collector.(*pubsubCollector)
+		if cfg.PubsubHealthInterval > 0 && isPubsub {
+			handler.health = make(chan string, 1)
+		}
+		// 写入同一个文件由 FileKeyStore 的互斥锁串行化，过滤、去重和元数据查询并行执行
+		workers := cfg.ConcurrentSubscriptions
+		if workers < 1 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			go handler.run(ctx, events)
+		}
+
+		if cfg.TestExpiry {
+			go verifySubscription(ctx, rdb, handler.probe)
+		}
+
+		// 订阅看似正常但不再收到消息时重新订阅
+		if isPubsub && handler.health != nil {
+			go checkSubscriptionHealth(ctx, rdb, cfg.PubsubHealthInterval, handler.health, pc.Reconnect, metrics)
+		}
+
+		// --pattern-file 变化时重新读取并重新订阅
+		if pc, ok := collector.(*pubsubCollector); ok && cfg.PatternFile != "" && cfg.WatchFilterFiles {
+			go func() {
+				err := watchFiles(ctx, []string{cfg.PatternFile}, func(name string) {
+					patterns, err := subscriptionPatterns(cfg)
+					if err != nil {
+						log.Printf("Failed to reload %s, keeping the current subscription: %v", name, err)
+						return
+					}
+					pc.Resubscribe(patterns)
+				})
+				if err != nil {
+					log.Printf("Failed to watch pattern file: %v", err)
+				}
+			}()
+		}
+
+		// 定期 PING 检测静默断开的连接，连续失败时让订阅重新连接
+		if cfg.ConnectionTestInterval > 0 {
+			var reconnect func()
+			if pc, ok := collector.(*pubsubCollector); ok {
+				reconnect = pc.Reconnect
+			}
+			go testConnection(ctx, rdb, cfg.ConnectionTestInterval, reconnect)
+		}
+	}
+
+	// 启动定时任务，在每天午夜执行惰性删除
+	// 按数据库分文件时，每个数据库独立清理，并错开执行时间避免同时冲击 Redis
+	var wg sync.WaitGroup
+	for n, cleaner := range cleaners {
+		offset := time.Duration(n) * 24 * time.Hour / time.Duration(len(cleaners))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cleaner.startDailyCleanup(ctx, offset); err != nil {
+				// 取消 ctx，让其他数据库的清理和事件处理一起退出
+				cleanupFailed.Store(true)
+				stop()
+			}
+		}()
+	}
+	wg.Wait()
+	log.Println("Shutting down")
+
+	// // 使用无限循环保持程序持续运行
+	// for {
+	// 	// 可以在这里添加一些其他的逻辑，或者让程序什么都不做，保持运行
+	// 	time.Sleep(1 * time.Second) // 暂时休眠，防止 CPU 占用过高
+	// }
+}
+
+// 根据配置选择过期事件来源：压测模式下生成虚假事件，可选从 Redis Stream 读取，
+// 兼容模式下定期 SCAN，默认订阅 keyspace 通知
+func newEventCollector(ctx context.Context, rdb *redis.Client, cfg *Config, metrics *Metrics) EventCollector {
+	switch {
+	case cfg.TestSynthetic:
+		log.Printf("Generating synthetic expired key events at %d keys/s", cfg.SyntheticRate)
+		return &syntheticCollector{rate: cfg.SyntheticRate, channel: channelName(cfg.ChannelPattern, cfg.DB)}
+	case cfg.UseStream:
+		log.Printf("Reading expired key events from stream %s", cfg.StreamKey)
+		return &RedisStreamSource{rdb: rdb, stream: cfg.StreamKey, offsetFile: cfg.KeyFile + ".stream_offset", startID: cfg.StreamStartID, db: cfg.DB}
+	case cfg.CompatMode == "scan":
+		log.Printf("Compat mode: scanning for expired keys every %v", cfg.ScanInterval)
+		return &scanBasedCollector{rdb: rdb, interval: cfg.ScanInterval, count: 1000, channel: channelName(cfg.ChannelPattern, cfg.DB), skipNoTTL: cfg.SkipKeysWithNoTTL}
+	case cfg.CompatMode == "pubsub":
+		checkRedisVersion(ctx, rdb)
+		if !cfg.NoKeyspaceEventSetup {
+			configureKeyspaceNotifications(ctx, rdb)
+		}
+
+		if needsSetEvents(cfg) && !cfg.NoKeyspaceEventSetup {
+			enableSetNotifications(ctx, rdb)
+		}
+		patterns, err := subscriptionPatterns(cfg)
+		if err != nil {
+			log.Fatalf("Failed to read --pattern-file: %v", err)
+		}
+		pubsub := rdb.PSubscribe(ctx, patterns...)
+
+		// 检查订阅是否成功
+		_, err = pubsub.Receive(ctx)
+		if err != nil {
+			log.Fatalf("Failed to subscribe to the channel: %v", err)
+		}
+		if cfg.NoKeyspaceEventSetup {
+			log.Println("WARN: Assuming keyspace notifications are already configured externally; tool may receive no events if they are not.")
+		}
+		return newPubsubCollector(rdb, patterns, pubsub, cfg.KeyEventsBufferSize, metrics)
+	default:
+		log.Fatalf("Unknown compat mode %q (expected pubsub or scan)", cfg.CompatMode)
+	}
+	return nil
+}
+
+// 订阅的频道模式：默认订阅过期事件频道，按数据库分文件时订阅所有数据库。
+// 指定 --patterns 或 --pattern-file 时一次订阅全部模式，例如同时订阅 expired 和 del 事件，
+// --pattern-file 优先
+func subscriptionPatterns(cfg *Config) ([]string, error) {
+	channelPattern := channelName(cfg.ChannelPattern, cfg.DB)
+	if cfg.PerDBFiles {
+		channelPattern = strings.Replace(cfg.ChannelPattern, "%d", "*", 1)
+	}
+	patterns := []string{channelPattern}
+	if cfg.PatternFile != "" {
+		filePatterns, err := loadPatterns(cfg.PatternFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(filePatterns) == 0 {
+			return nil, fmt.Errorf("no patterns in %s", cfg.PatternFile)
+		}
+		patterns = filePatterns
+	} else if cfg.Patterns != "" {
+		patterns = splitPatterns(cfg.Patterns)
+	}
+	// --track-re-sets 和 --key-value-export-before-expiry 需要同时订阅 set 事件
+	if needsSetEvents(cfg) {
+		patterns = append(patterns, setEventPatterns(patterns)...)
+	}
+	return patterns, nil
+}
+
+// 检查 notify-keyspace-events 配置，必要时开启过期通知。
+// 托管的 Redis 服务通常禁用了 CONFIG 命令，需要指定 --no-keyspace-event-setup 并在服务侧开启通知 (值至少包含 Ex)：
+//   - AWS ElastiCache：在参数组中设置 notify-keyspace-events
+//   - Azure Cache for Redis：在 "高级设置" 中设置 notify-keyspace-events
+//   - Google Cloud Memorystore：设置实例的 Redis 配置 notify-keyspace-events
+//   - Heroku Redis：heroku redis:keyspace-notifications <database> -c Ex
+func configureKeyspaceNotifications(ctx context.Context, rdb *redis.Client) {
+	// 检查当前 notify-keyspace-events 配置
+	currentConfig, err := rdb.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		log.Fatalf("Failed to get configuration: %v", err)
+	}
+
+	// currentConfig[1] 是 interface{} 类型，我们需要类型断言为 string
+	if len(currentConfig) < 2 {
+		log.Fatal("Failed to get notify-keyspace-events configuration")
+	}
+
+	configValue, ok := currentConfig[1].(string)
+	if !ok {
+		log.Fatal("Failed to convert config value to string")
+	}
+
+	// 判断是否已经配置过期通知 (检查 "E" 或 "x" 是否在配置字符串中)
+	log.Println("Configured notify-keyspace-events")
+	if !(strings.Contains(configValue, "E") || strings.Contains(configValue, "x")) {
+		_, err := rdb.ConfigSet(ctx, "notify-keyspace-events", "Ex").Result()
+		if err != nil {
+			log.Fatalf("Failed to set configuration: %v", err)
+		}
+		log.Println("Configured notify-keyspace-events to 'Ex'")
+	} else {
+		log.Println("notify-keyspace-events is already configured to support expiration notifications")
+	}
+}
+
+// 将已经加上分隔的记录一次性追加到文件中
+func appendFramesToFile(filePath string, data []byte) error {
+	file, err := openLocked(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, true)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	defer unlockFile(file)
+
+	_, err = file.Write(data)
+	return err
+}
+
+// Cleaner 对单个过期键文件执行定时惰性删除
+type Cleaner struct {
+	rdb     RedisClient
+	store   *FileKeyStore
+	cfg     *Config
+	audit   *AuditLog
+	metrics *Metrics
+
+	strategy  DeletionStrategy // 处理每个键的方式 (--deletion-strategy)
+	keyStats  *KeyStatsFile    // 按键保存最近一次的处理统计，为 nil 时不记录
+	locker    cleanupLocker    // 防止多个实例同时清理 (--lock-backend)，为 nil 时不加锁
+	status    *StatusStore     // 记录清理状态 (--status-file)，为 nil 时不记录
+	slots     chan struct{}    // 同时进行的清理数的信号量 (--max-concurrent-cleanups)，为 nil 时不限制
+	filter    *keyFilter       // 黑名单 / 白名单，为 nil 时处理所有键
+	prefixes  *prefixStats     // 每次清理结束时输出按前缀的过期统计
+	lastStats *cleanupStats    // 最近一次清理的统计
+	progress  *cleanupProgress // 正在进行的清理的进度，未开启 --report-interval 时为 nil
+
+	// --key-store-backend=postgres 时从 PostgreSQL 读取过期键，为 nil 时读取过期键文件
+	pg *PostgresKeyStore
+
+	// 开启 --ignore-errors 时本次清理失败的键，并行分片时由多个 goroutine 写入
+	failedMu   sync.Mutex
+	failedKeys []string
+
+	// 本次清理从过期键文件读到的键 -> 文件中的原始记录，中断时原样写回
+	records map[string]string
+}
+
+// 每天在 --once-at 指定的时间 (默认零点，加上 offset) 执行惰性删除
+// ctx 取消时立即返回，开启 --exit-on-cleanup-error 时清理失败返回错误
+func (c *Cleaner) startDailyCleanup(ctx context.Context, offset time.Duration) error {
+	for {
+		// 等待直到下一次执行时间
+		next, err := nextOccurrence(time.Now(), c.cfg.OnceAt)
+		if err != nil {
+			log.Fatalf("Invalid cleanup time: %v", err)
+		}
+		// --cleanup-jitter 每次重新随机，错开多个实例的清理时间
+		next = next.Add(offset + randomJitter(c.cfg.CleanupJitter))
+		c.status.SetNextRun(next)
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return nil
+		}
+
+		// 执行清理
+		if err := c.cleanupError(c.runCleanup(ctx)); err != nil {
+			return err
+		}
+	}
+}
+
+// 记录清理失败。开启 --exit-on-cleanup-error 时返回 err 让调用方退出，
+// 否则只计数，等待下一次清理
+func (c *Cleaner) cleanupError(err error) error {
+	if err == nil {
+		return nil
+	}
+	c.metrics.Count(metricCleanupErrors, 1, nil)
+	if c.cfg.ExitOnCleanupError {
+		log.Printf("Error during lazy deletion, shutting down: %v", err)
+		return err
+	}
+	log.Printf("Error during lazy deletion, retrying at the next scheduled cleanup: %v", err)
+	return nil
+}
+
+// 启动时文件非空则立即执行一次清理
+func (c *Cleaner) startupCleanup(ctx context.Context) error {
+	n, err := countLines(c.store.Path(), c.store.format)
+	if err != nil {
+		log.Fatalf("Failed to read key file: %v", err)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	log.Printf("Found %d existing keys, running startup cleanup", n)
+	return c.cleanupError(c.runCleanup(ctx))
+}
+
+// 执行一次清理，前后分别调用 --pre-cleanup-hook 和 --post-cleanup-hook，
+// 前置脚本返回非 0 时跳过本次清理
+func (c *Cleaner) runCleanup(ctx context.Context) error {
+	// 已有 --max-concurrent-cleanups 个清理在进行时跳过本次清理
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+			defer func() { <-c.slots }()
+		default:
+			log.Println("WARN: Skipping cleanup: previous run still in progress.")
+			return nil
+		}
+	}
+
+	// Redis 不可用时跳过本次清理，避免每个键都失败一次
+	if c.cfg.RedisPingOnCleanup {
+		pingCtx, cancel := context.WithTimeout(ctx, c.cfg.CleanupPingTimeout)
+		err := c.rdb.Ping(pingCtx).Err()
+		cancel()
+		if err != nil {
+			log.Printf("WARN: Redis is not available, skipping lazy deletion until the next run: %v", err)
+			return nil
+		}
+	}
+
+	// 其他实例正在清理时跳过本次清理
+	if c.locker != nil {
+		var name string
+		if c.cfg.PerDBFiles {
+			name = strconv.Itoa(c.rdb.Options().DB)
+		}
+		unlock, ok, err := c.locker.TryLock(ctx, name)
+		if err != nil {
+			log.Printf("Failed to acquire cleanup lock, skipping lazy deletion: %v", err)
+			return nil
+		}
+		if !ok {
+			log.Println("Cleanup lock is held by another instance, skipping lazy deletion")
+			return nil
+		}
+		defer unlock()
+	}
+
+	if c.cfg.PreCleanupHook != "" {
+		if err := runHook(ctx, c.cfg.PreCleanupHook, nil); err != nil {
+			log.Printf("Pre-cleanup hook failed, skipping lazy deletion: %v", err)
+			return nil
+		}
+	}
+
+	// 清理期间不轮换 --key-file-encrypt 的密钥，见 keyRotationMu
+	keyRotationMu.RLock()
+	defer keyRotationMu.RUnlock()
+
+	c.lastStats = newCleanupStats()
+	c.status.CleanupStarted()
+	ctx, span := otel.Tracer(tracerName).Start(ctx, spanCleanup, trace.WithAttributes(attribute.Int("db", c.rdb.Options().DB)))
+	err := c.performLazyDelete(ctx, c.strategy)
+	span.SetAttributes(attribute.Int("keys_processed", c.lastStats.processed), attribute.Int("keys_deleted", c.lastStats.deleted))
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+	c.status.CleanupFinished(c.lastStats.start, c.lastStats.processed)
+	c.prefixes.Report()
+
+	// 处理完过期键文件后，再扫描 pubsub 没有捕获到的孤儿键
+	if err == nil && c.cfg.ScanOrphans {
+		if scanErr := c.scanOrphans(ctx); scanErr != nil && ctx.Err() == nil {
+			// 某个键失败与处理过期键文件时相同，交给 --exit-on-cleanup-error 处理
+			if _, ok := scanErr.(*keyError); ok {
+				err = scanErr
+			} else {
+				log.Printf("Failed to scan for orphaned expired keys: %v", scanErr)
+			}
+		}
+	}
+
+	if c.cfg.PostCleanupHook != "" {
+		stats := c.lastStats
+		env := []string{
+			fmt.Sprintf("KEYS_PROCESSED=%d", stats.processed),
+			fmt.Sprintf("KEYS_DELETED=%d", stats.deleted),
+			fmt.Sprintf("DURATION_MS=%d", time.Since(stats.start).Milliseconds()),
+		}
+		if err := runHook(ctx, c.cfg.PostCleanupHook, env); err != nil {
+			log.Printf("Post-cleanup hook failed: %v", err)
+		}
+	}
+	return err
+}
+
+// 执行惰性删除操作，过期键文件中的每个键按 strategy 处理
+func (c *Cleaner) performLazyDelete(ctx context.Context, strategy DeletionStrategy) error {
+	if c.pg != nil {
+		return c.lazyDeleteFromPostgres(ctx, strategy)
+	}
+	if c.cfg.SimulateFile != "" {
+		return c.simulateLazyDelete(ctx, strategy)
+	}
+	filePath := c.store.Path()
+	db := c.rdb.Options().DB
+
+	// 过期键文件不存在时默认创建空文件，开启 --stop-if-file-missing 时返回错误
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		if c.cfg.StopIfFileMissing {
+			return fmt.Errorf("expired keys file %s not found", filePath)
+		}
+		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		file.Close()
+		debugf("Created missing expired keys file %s, skipping lazy deletion", filePath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-	// 解析命令行参数
-	flag.Parse()
+	// 开启 --key-file-checksum 时先确认文件没有被损坏、截断或被外部修改
+	if err := c.store.VerifyChecksum(); err != nil {
+		log.Fatalf("Key file is corrupted, manual intervention required: %v", err)
+	}
 
-	// 创建 Redis 客户端
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     *addr,     // Redis 地址
-		Password: *password, // Redis 密码
-		DB:       *db,       // Redis 数据库
-	})
+	// 过期键文件为空时，直接跳过本轮清理
+	if info.Size() == 0 {
+		debugf("No expired keys in %s, skipping lazy deletion", filePath)
+		return nil
+	}
 
-	ctx := context.Background()
+	log.Println("Start lazily deleting")
 
-	// 检查当前 notify-keyspace-events 配置
-	currentConfig, err := rdb.ConfigGet(ctx, "notify-keyspace-events").Result()
-	if err != nil {
-		log.Fatalf("Failed to get configuration: %v", err)
+	// 读取存储的过期键。开启 --no-backup 时直接读入内存并清空文件，
+	// 没有备份文件，backupFilePath 为空
+	var file io.Reader
+	var backupFilePath string
+	if c.cfg.NoBackup {
+		data, err := c.store.Take()
+		if err != nil {
+			return err
+		}
+		file = bytes.NewReader(data)
+	} else {
+		// 保留最近 --keep-backups 个备份，失败的清理留下的备份不会被覆盖
+		if err := rotateBackups(filePath, c.cfg.KeepBackups, c.cfg.KeyFileCompression); err != nil {
+			return fmt.Errorf("failed to rotate backups: %v", err)
+		}
+		backupFilePath = backupPath(filePath, 1, c.cfg.KeyFileCompression)
+		if err := c.store.Drain(backupFilePath); err != nil {
+			return err
+		}
+		backup, err := openBackupFile(backupFilePath)
+		if err != nil {
+			return err
+		}
+		defer backup.Close()
+		file = backup
 	}
 
-	// currentConfig[1] 是 interface{} 类型，我们需要类型断言为 string
-	if len(currentConfig) < 2 {
-		log.Fatal("Failed to get notify-keyspace-events configuration")
+	// 上次清理失败的键 (--errors-file) 排在本次清理的最前面
+	keysToCheck, err := c.takeFailedKeys()
+	if err != nil {
+		return fmt.Errorf("failed to read errors file: %v", err)
+	}
+	// 读取每一行（即过期键），兼容纯文本和 JSON 格式。备份文件和 Take 返回的内容都已解密
+	var total, tooOld int
+	c.records = make(map[string]string)
+	scanner := newFrameScanner(file, c.store.format)
+	for scanner.Scan() {
+		if rec, ok := parseRecord(scanner.Text(), c.store.format); ok {
+			total++
+			if c.tooOld(rec) {
+				tooOld++
+				continue
+			}
+			keysToCheck = append(keysToCheck, rec.Key)
+			c.records[rec.Key] = scanner.Text()
+			c.keyStats.NoteEvent(rec.Key, db, rec.TS)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if tooOld > 0 {
+		c.metrics.Count(metricKeysTooOld, int64(tooOld), nil)
+		log.Printf("Skipped %d/%d keys older than --key-age-threshold %v", tooOld, total, c.cfg.KeyAgeThreshold)
+		if float64(tooOld) > float64(total)*c.cfg.KeyAgeWarnPercent/100 {
+			log.Printf("WARN: %.0f%% of keys in %s are older than %v, is the cleanup schedule broken?",
+				float64(tooOld)*100/float64(total), filePath, c.cfg.KeyAgeThreshold)
+		}
 	}
+	// 读出的记录在 copyRecords 中已经去重，这里的键数即本次清理的唯一键数
+	c.metrics.Observe(metricUniqueKeysPerRun, float64(len(keysToCheck)), nil)
 
-	configValue, ok := currentConfig[1].(string)
-	if !ok {
-		log.Fatal("Failed to convert config value to string")
+	// 限制单次清理的最长时间，超时或程序退出时未处理的键写回文件，留给下一次清理
+	if c.cfg.MaxCleanupDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.MaxCleanupDuration)
+		defer cancel()
 	}
 
-	// 判断是否已经配置过期通知 (检查 "E" 或 "x" 是否在配置字符串中)
-	log.Println("Configured notify-keyspace-events")
-	if !(strings.Contains(configValue, "E") || strings.Contains(configValue, "x")) {
-		_, err := rdb.ConfigSet(ctx, "notify-keyspace-events", "Ex").Result()
-		if err != nil {
-			log.Fatalf("Failed to set configuration: %v", err)
+	stats := c.lastStats
+	c.failedKeys = nil
+	defer c.saveFailedKeys()
+	defer func() {
+		if err := c.keyStats.Merge(); err != nil {
+			log.Printf("Failed to write key stats file: %v", err)
 		}
-		log.Println("Configured notify-keyspace-events to 'Ex'")
+	}()
+	defer func() {
+		log.Printf("Lazy deletion summary: %v", stats)
+		c.metrics.Observe(metricCleanupDuration, time.Since(stats.start).Seconds(), nil)
+	}()
+
+	// 开启 --report-interval 时在清理期间定期输出进度，清理结束时停止
+	if c.cfg.ReportInterval > 0 {
+		c.progress = newCleanupProgress(len(keysToCheck))
+		reportCtx, stopReport := context.WithCancel(ctx)
+		defer stopReport()
+		go c.progress.Run(reportCtx, c.cfg.ReportInterval)
 	} else {
-		log.Println("notify-keyspace-events is already configured to support expiration notifications")
+		c.progress = nil
+	}
+
+	if c.cfg.TransactionBatch {
+		return c.lazyDeleteInTransactions(ctx, keysToCheck, db, strategy, stats, backupFilePath)
+	}
+	if c.cfg.ParallelCleanupShards > 1 {
+		return c.lazyDeleteInShards(ctx, keysToCheck, db, strategy, stats, backupFilePath)
+	}
+
+	if c.cfg.KeyGroupBy == keyGroupByPrefix {
+		return c.lazyDeleteInGroups(ctx, keysToCheck, db, strategy, stats, backupFilePath)
+	}
+
+	if processed, err := c.processKeys(ctx, keysToCheck, db, strategy, stats); processed < len(keysToCheck) {
+		return c.abortLazyDelete(err, keysToCheck, processed, backupFilePath)
+	}
+	return c.finishLazyDelete(backupFilePath)
+}
+
+// --dry-run-simulate-file：从测试文件读取键并依次处理，用于在没有真实过期流量时演示清理。
+// 不备份、不清空过期键文件，中断时也不写回，测试文件本身只读
+func (c *Cleaner) simulateLazyDelete(ctx context.Context, strategy DeletionStrategy) error {
+	file, err := os.Open(c.cfg.SimulateFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(file)
+	growScanBuffer(scanner)
+	for scanner.Scan() {
+		if rec, ok := parseKeyLine(scanner.Text()); ok {
+			keys = append(keys, rec.Key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
 	}
 
-	// 订阅过期事件频道
-	pubsub := rdb.PSubscribe(ctx, "__keyevent@0__:expired")
-	defer pubsub.Close()
+	log.Printf("Start lazily deleting %d keys from simulate file %s", len(keys), c.cfg.SimulateFile)
+	c.metrics.Observe(metricUniqueKeysPerRun, float64(len(keys)), nil)
+	stats := c.lastStats
+	defer func() {
+		log.Printf("Lazy deletion summary: %v", stats)
+		c.metrics.Observe(metricCleanupDuration, time.Since(stats.start).Seconds(), nil)
+	}()
+	processed, err := c.processKeys(ctx, keys, c.rdb.Options().DB, strategy, stats)
+	if processed < len(keys) {
+		log.Printf("Lazy deletion interrupted (%v): processed %d/%d keys", err, processed, len(keys))
+	}
+	if _, ok := err.(*keyError); ok {
+		return err
+	}
+	return nil
+}
 
-	// 检查订阅是否成功
-	_, err = pubsub.Receive(ctx)
+// 开启 --key-age-threshold 时，过期时间早于阈值的记录已不值得处理：键肯定早已被删除。
+// 只有 JSON 格式的记录带有时间戳，没有时间戳的记录照常处理
+func (c *Cleaner) tooOld(rec KeyRecord) bool {
+	if c.cfg.KeyAgeThreshold <= 0 || rec.TS == "" {
+		return false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, rec.TS)
 	if err != nil {
-		log.Fatalf("Failed to subscribe to the channel: %v", err)
+		return false
 	}
+	return time.Since(ts) > c.cfg.KeyAgeThreshold
+}
 
-	// 存储过期键的文件路径
-	expiredFilePath := ".expired_keys"
+// 依次处理 keys，返回处理完的键数，小于 len(keys) 时同时返回中止的原因：
+// ctx 已结束时为 ctx.Err()，未开启 --ignore-errors 时某个键失败为 *keyError，该键不计入已处理
+func (c *Cleaner) processKeys(ctx context.Context, keys []string, db int, strategy DeletionStrategy, stats *cleanupStats) (int, error) {
+	// 执行惰性删除操作（访问键以触发过期删除）
+	for i, key := range keys {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		c.progress.add(1)
 
-	// 启动一个 goroutine 来处理过期事件
-	go func() {
-		for msg := range pubsub.Channel() {
-			log.Printf("Receive Key expired: %s\n", msg.Payload) // 打印过期的键名
+		if !c.filter.Allow(key) {
+			debugf("Skipping filtered key %s", key)
+			continue
+		}
 
-			// 记录过期键到文件
-			err := appendExpiredKeyToFile(expiredFilePath, msg.Payload)
-			if err != nil {
-				log.Fatalf("Failed to write expired key to file: %v", err)
+		err := c.touchKey(ctx, key, db, "file", strategy, stats)
+		if err != nil && ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		if category := classifyError(err); category.skippable() {
+			deduplicateLog(keyPrefix(key, c.cfg.NamespaceSeparator), "Skipping key %s after %v error: %v", key, category, err)
+			continue
+		} else if err != nil {
+			if err := c.keyFailed(key, category, err); err != nil {
+				return i, err
 			}
+			continue
 		}
-	}()
 
-	// 启动定时任务，在每天午夜执行惰性删除
-	startDailyCleanup(rdb, expiredFilePath, *interval)
+		select {
+		case <-time.After(resolveInterval(key, c.cfg.Intervals)):
+		case <-ctx.Done():
+		}
+	}
+	return len(keys), nil
+}
 
-	// // 使用无限循环保持程序持续运行
-	// for {
-	// 	// 可以在这里添加一些其他的逻辑，或者让程序什么都不做，保持运行
-	// 	time.Sleep(1 * time.Second) // 暂时休眠，防止 CPU 占用过高
-	// }
+// --key-group-by 的取值
+const keyGroupByPrefix = "prefix"
+
+// 按前缀排序后逐组处理 (--key-group-by=prefix)，两组之间等待 --inter-group-delay，
+// 组内仍按 --config 中的删除间隔处理每个键。同一前缀的键集中在一起，
+// 大量同时过期的 session: 键不会和其他前缀的键交错，负载更可预测
+func (c *Cleaner) lazyDeleteInGroups(ctx context.Context, keys []string, db int, strategy DeletionStrategy, stats *cleanupStats, backupFilePath string) error {
+	sep := c.cfg.NamespaceSeparator
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keyPrefix(keys[i], sep) < keyPrefix(keys[j], sep)
+	})
+
+	processed := 0
+	for start := 0; start < len(keys); {
+		prefix := keyPrefix(keys[start], sep)
+		end := start + 1
+		for end < len(keys) && keyPrefix(keys[end], sep) == prefix {
+			end++
+		}
+
+		if start > 0 && c.cfg.InterGroupDelay > 0 {
+			select {
+			case <-time.After(c.cfg.InterGroupDelay):
+			case <-ctx.Done():
+				return c.abortLazyDelete(ctx.Err(), keys, processed, backupFilePath)
+			}
+		}
+		debugf("Processing %d keys with prefix %s", end-start, prefix)
+		n, err := c.processKeys(ctx, keys[start:end], db, strategy, stats)
+		processed += n
+		if n < end-start {
+			return c.abortLazyDelete(err, keys, processed, backupFilePath)
+		}
+		start = end
+	}
+	return c.finishLazyDelete(backupFilePath)
 }
 
-// 将过期键追加到文件中
-func appendExpiredKeyToFile(filePath, key string) error {
-	// 打开文件，如果文件不存在则创建
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+// 按键名哈希将键分成 --parallel-cleanup-shards 份，每份在单独的 goroutine 中处理，
+// 最后合并统计。中断时各分片未处理的键一起写回文件，一个分片中止时其他分片也随之停止
+func (c *Cleaner) lazyDeleteInShards(ctx context.Context, keys []string, db int, strategy DeletionStrategy, stats *cleanupStats, backupFilePath string) error {
+	shards := shardKeys(keys, c.cfg.ParallelCleanupShards)
+	processed := make([]int, len(shards))
+	errs := make([]error, len(shards))
+	shardStats := make([]*cleanupStats, len(shards))
+	shardCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		shardStats[i] = newCleanupStats()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processed[i], errs[i] = c.processKeys(shardCtx, shard, db, strategy, shardStats[i])
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
 	}
-	defer file.Close()
+	wg.Wait()
 
-	// 将过期键写入文件
-	_, err = file.WriteString(key + "\n")
-	return err
+	// 已处理的键排在前面，未处理的键排在后面，以便 abortLazyDelete 写回。
+	// 某个键失败导致的中止优先于其他分片随之取消的原因
+	var done, remaining []string
+	cause := ctx.Err()
+	for i, shard := range shards {
+		stats.merge(shardStats[i])
+		done = append(done, shard[:processed[i]]...)
+		remaining = append(remaining, shard[processed[i]:]...)
+		if _, ok := errs[i].(*keyError); ok && ctx.Err() == nil {
+			cause = errs[i]
+		}
+	}
+	if len(remaining) > 0 {
+		return c.abortLazyDelete(cause, append(done, remaining...), len(done), backupFilePath)
+	}
+	return c.finishLazyDelete(backupFilePath)
+}
+
+// shardKeys 使用 FNV-1a 哈希将键稳定地分到 n 个分片中
+func shardKeys(keys []string, n int) [][]string {
+	if n < 1 {
+		n = 1
+	}
+	shards := make([][]string, n)
+	for _, key := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		i := h.Sum32() % uint32(n)
+		shards[i] = append(shards[i], key)
+	}
+	return shards
 }
 
-// 每天零点执行惰性删除
-func startDailyCleanup(rdb *redis.Client, filePath string, interval int) {
-	// 设置每天午夜 0 点执行任务
-	ticker := time.NewTicker(24 * time.Hour)
+// 按命名空间前缀将键分组，每组在一个 MULTI/EXEC 事务中处理，避免同一逻辑会话的键只清理了一部分。
+// 事务失败时该组退回逐个处理。事务只适用于单实例 Redis：Cluster 模式下同一事务的键必须位于同一个槽
+func (c *Cleaner) lazyDeleteInTransactions(ctx context.Context, keys []string, db int, strategy DeletionStrategy, stats *cleanupStats, backupFilePath string) error {
+	// 按前缀首次出现的顺序分组，中断时按分组后的顺序写回未处理的键
+	var prefixes []string
+	groups := make(map[string][]string)
+	for _, key := range keys {
+		if !c.filter.Allow(key) {
+			debugf("Skipping filtered key %s", key)
+			c.progress.add(1)
+			continue
+		}
+		prefix := keyPrefix(key, c.cfg.NamespaceSeparator)
+		if _, ok := groups[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+		groups[prefix] = append(groups[prefix], key)
+	}
+	ordered := make([]string, 0, len(keys))
+	for _, prefix := range prefixes {
+		ordered = append(ordered, groups[prefix]...)
+	}
 
-	// 等待直到每天的 0 点
-	now := time.Now()
-	waitUntilMidnight := time.Until(time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()))
-	time.Sleep(waitUntilMidnight)
+	processed := 0
+	for _, prefix := range prefixes {
+		if ctx.Err() != nil {
+			return c.abortLazyDelete(ctx.Err(), ordered, processed, backupFilePath)
+		}
 
-	for {
-		// 在零点执行清理
-		err := performLazyDelete(rdb, filePath, interval)
+		batch := groups[prefix]
+		err := c.touchBatch(ctx, batch, db, strategy, stats)
+		if err != nil && ctx.Err() != nil {
+			return c.abortLazyDelete(ctx.Err(), ordered, processed, backupFilePath)
+		}
 		if err != nil {
-			log.Fatalf("Error during lazy deletion: %v", err)
+			log.Printf("WARN: Transaction for prefix %s failed, falling back to individual keys: %v", prefix, err)
+			for i, key := range batch {
+				err := c.touchKey(ctx, key, db, "file", strategy, stats)
+				if err != nil && ctx.Err() != nil {
+					return c.abortLazyDelete(ctx.Err(), ordered, processed+i, backupFilePath)
+				}
+				if category := classifyError(err); category.skippable() {
+					deduplicateLog(keyPrefix(key, c.cfg.NamespaceSeparator), "Skipping key %s after %v error: %v", key, category, err)
+				} else if err != nil {
+					if err := c.keyFailed(key, category, err); err != nil {
+						return c.abortLazyDelete(err, ordered, processed+i, backupFilePath)
+					}
+				}
+			}
+		} else {
+			debugf("%s %d keys with prefix %s in a transaction", strings.ToUpper(c.cfg.DeletionStrategy), len(batch), prefix)
 		}
+		processed += len(batch)
+		c.progress.add(len(batch))
 
-		// 等待下次零点
-		<-ticker.C
+		select {
+		case <-time.After(resolveInterval(batch[0], c.cfg.Intervals)):
+		case <-ctx.Done():
+		}
 	}
+
+	return c.finishLazyDelete(backupFilePath)
 }
 
-// 执行惰性删除操作
-func performLazyDelete(rdb *redis.Client, filePath string, interval int) error {
-	log.Println("Start lazily deleting")
+// 清理完成：--keep-backups 为 0 时删除备份文件，否则留作恢复用
+func (c *Cleaner) finishLazyDelete(backupFilePath string) error {
+	if backupFilePath != "" && c.cfg.KeepBackups == 0 {
+		return os.Remove(backupFilePath)
+	}
+	return nil
+}
 
-	backupFilePath := filePath + ".bak"
-	err := copyFile(filePath, backupFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to backup file: %v", err)
+// 调用 OBJECT REFCOUNT 查看键的值是否为共享对象 (例如共享整数)，结果写入审计日志
+func (c *Cleaner) inspectRefcount(ctx context.Context, key string, db int, stats *cleanupStats) {
+	start := time.Now()
+	refcount, err := c.rdb.ObjectRefCount(ctx, key).Result()
+	if err == redis.Nil {
+		return
+	} else if err != nil {
+		deduplicateLog(keyPrefix(key, c.cfg.NamespaceSeparator), "Failed to get refcount of key %s: %v", key, err)
+		return
+	}
+	if refcount > 1 {
+		debugf("key %s is a shared object (refcount %d)", key, refcount)
+		stats.shared++
 	}
-	err = os.Truncate(filePath, 0)
+	if err := c.audit.Record(key, db, "OBJECT REFCOUNT", strconv.FormatInt(refcount, 10), time.Since(start)); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// 按 strategy 处理键 (默认通过 TYPE 触发惰性删除)，并将结果计入统计、指标和审计日志，
+// source 表示键的来源：file (过期键文件) 或 scan (孤儿键扫描)
+func (c *Cleaner) touchKey(ctx context.Context, key string, db int, source string, strategy DeletionStrategy, stats *cleanupStats) error {
+	start := time.Now()
+	c.inspectKey(ctx, key, db, stats)
+	info := c.keyStats.Inspect(ctx, c.rdb, key)
+
+	// 按 --deletion-strategy 处理键，连接失败、超时等临时错误先重试
+	var outcome string
+	err := retryOnTransient(ctx, func() (err error) {
+		outcome, err = strategy.Execute(ctx, c.rdb, key)
+		return err
+	})
 	if err != nil {
+		if ctx.Err() == nil {
+			c.recordOutcome(key, db, source, outcomeError, time.Since(start), stats)
+			c.keyStats.Record(key, db, strings.ToUpper(c.cfg.DeletionStrategy), outcomeError, time.Since(start), info)
+		}
 		return err
 	}
-	// 读取存储的过期键
-	file, err := os.Open(backupFilePath)
+
+	debugf("%s key %s: %s source=%s", strings.ToUpper(c.cfg.DeletionStrategy), key, outcome, source)
+	if outcome == outcomeDeleted {
+		c.verifyDeleted(ctx, key)
+	}
+	c.recordOutcome(key, db, source, outcome, time.Since(start), stats)
+	c.keyStats.Record(key, db, strings.ToUpper(c.cfg.DeletionStrategy), outcome, time.Since(start), info)
+	return nil
+}
+
+// 开启 --verify-after-delete 时用 EXISTS 确认键已被删除，
+// 键仍然存在通常说明应用在极短的时间窗口内重新创建了它
+func (c *Cleaner) verifyDeleted(ctx context.Context, key string) {
+	if !c.cfg.VerifyAfterDelete {
+		return
+	}
+	n, err := c.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		deduplicateLog(keyPrefix(key, c.cfg.NamespaceSeparator), "Failed to verify deletion of key %s: %v", key, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("WARN: Key %s still exists after deletion, possibly re-created by the application", key)
+		c.metrics.Count(metricVerifyStillPresent, 1, nil)
+	}
+}
+
+// 在一个 MULTI/EXEC 事务中按 strategy 处理 keys，使这些键同时被惰性删除或删除。
+// 事务失败或 strategy 不支持事务时返回错误，由调用方退回逐个处理
+func (c *Cleaner) touchBatch(ctx context.Context, keys []string, db int, strategy DeletionStrategy, stats *cleanupStats) error {
+	batch, ok := strategy.(batchStrategy)
+	if !ok {
+		return fmt.Errorf("deletion strategy %s does not support transactions", c.cfg.DeletionStrategy)
+	}
+	for _, key := range keys {
+		c.inspectKey(ctx, key, db, stats)
+	}
+
+	start := time.Now()
+	results := make([]func() (string, error), len(keys))
+	_, err := c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			results[i] = batch.Queue(ctx, pipe, key)
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	var keysToCheck []string
-	// 读取每一行（即过期键）
-	var key string
+	latency := time.Since(start)
+	outcomes := make([]string, len(keys))
+	for i, result := range results {
+		if outcomes[i], err = result(); err != nil {
+			return err
+		}
+	}
+	for i, outcome := range outcomes {
+		if outcome == outcomeDeleted {
+			c.verifyDeleted(ctx, keys[i])
+		}
+		c.recordOutcome(keys[i], db, "file", outcome, latency, stats)
+	}
+	return nil
+}
+
+// 删除前按配置查看键的编码和引用计数
+func (c *Cleaner) inspectKey(ctx context.Context, key string, db int, stats *cleanupStats) {
+	// 删除前查看键的编码，便于发现可以调优编码的键类型
+	if c.cfg.InspectEncoding {
+		c.inspectEncoding(ctx, key, stats)
+	}
+	if c.cfg.InspectRefcount {
+		c.inspectRefcount(ctx, key, db, stats)
+	}
+}
+
+// 将一个键的处理结果计入统计、指标和审计日志
+func (c *Cleaner) recordOutcome(key string, db int, source, outcome string, latency time.Duration, stats *cleanupStats) {
+	stats.record(outcome)
+	c.metrics.Count(metricKeysProcessed, 1, map[string]string{"outcome": outcome, "source": source})
+	c.recordAudit(key, db, outcome, latency)
+}
+
+// 扫描 Redis 中已过期 (TTL 为 -2) 但仍未被清除的键，例如工具离线期间过期的键，
+// 按与过期键文件相同的方式处理，单个键出错时与 processKeys 一样跳过或记入 --errors-file
+func (c *Cleaner) scanOrphans(ctx context.Context) error {
+	db := c.rdb.Options().DB
+	stats := newCleanupStats()
+	defer log.Printf("Orphan scan summary: %v source=scan", stats)
+	defer c.saveFailedKeys()
+
+	var cursor uint64
 	for {
-		_, err := fmt.Fscanf(file, "%s\n", &key)
+		keys, next, err := c.rdb.Scan(ctx, cursor, "*", 1000).Result()
 		if err != nil {
-			break
+			return err
+		}
+
+		if len(keys) > 0 {
+			cmds, err := c.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.TTL(ctx, key)
+				}
+				return nil
+			})
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			for i, cmd := range cmds {
+				if !scanCandidate(cmd.(*redis.DurationCmd).Val(), c.cfg.SkipKeysWithNoTTL) || !c.filter.Allow(keys[i]) {
+					continue
+				}
+				err := c.touchKey(ctx, keys[i], db, "scan", c.strategy, stats)
+				if err != nil && ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if category := classifyError(err); category.skippable() {
+					deduplicateLog(keyPrefix(keys[i], c.cfg.NamespaceSeparator), "Skipping key %s after %v error: %v source=scan", keys[i], category, err)
+					continue
+				} else if err != nil {
+					if err := c.keyFailed(keys[i], category, err); err != nil {
+						return err
+					}
+					continue
+				}
+
+				select {
+				case <-time.After(resolveInterval(keys[i], c.cfg.Intervals)):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
 		}
-		keysToCheck = append(keysToCheck, key)
 	}
+}
 
-	// 执行惰性删除操作（访问键以触发过期删除）
-	for _, key := range keysToCheck {
-		// 获取键的类型
-		_, err := rdb.Type(context.Background(), key).Result()
-		if err != nil {
-			log.Fatalf("Failed to get type of key %s: %v\n", key, err)
-			continue
+// 调用 OBJECT ENCODING 记录键的编码，键不存在时记为 none
+func (c *Cleaner) inspectEncoding(ctx context.Context, key string, stats *cleanupStats) {
+	encoding, err := c.rdb.ObjectEncoding(ctx, key).Result()
+	if err == redis.Nil {
+		encoding = "none"
+	} else if err != nil {
+		deduplicateLog(keyPrefix(key, c.cfg.NamespaceSeparator), "Failed to get encoding of key %s: %v", key, err)
+		return
+	}
+	debugf("encoding of key %s: %s", key, encoding)
+	stats.recordEncoding(encoding)
+	c.metrics.observeEncoding(encoding)
+}
+
+// 清理中断：将第 processed 个之后的键写回过期键文件，并删除备份文件。
+// 从过期键文件读到的键写回原始记录，上次失败的键 (--errors-file) 只有键名。
+// cause 为 ctx.Err() (超时或程序退出) 时返回 nil，为 *keyError 时写回后返回 cause
+func (c *Cleaner) abortLazyDelete(cause error, keys []string, processed int, backupFilePath string) error {
+	remaining := keys[processed:]
+	log.Printf("Lazy deletion interrupted (%v): processed %d/%d keys, requeued %d keys",
+		cause, processed, len(keys), len(remaining))
+
+	lines := make([]string, len(remaining))
+	for i, key := range remaining {
+		if line, ok := c.records[key]; ok {
+			lines[i] = line
 		} else {
-			log.Printf("get type of key %s\n", key)
+			lines[i] = key
+		}
+	}
+	if err := c.store.Requeue(lines); err != nil {
+		return fmt.Errorf("failed to requeue unprocessed keys: %v", err)
+	}
+	if backupFilePath != "" {
+		if err := os.Remove(backupFilePath); err != nil {
+			return err
 		}
+	}
+	if _, ok := cause.(*keyError); ok {
+		return cause
+	}
+	return nil
+}
 
-		time.Sleep(time.Duration(interval) * time.Millisecond)
+// 处理单个键失败：默认返回 *keyError 中止本次清理，
+// 开启 --ignore-errors 时记录失败的键并返回 nil，继续处理下一个键
+func (c *Cleaner) keyFailed(key string, category ErrorCategory, err error) error {
+	if !c.cfg.IgnoreErrors {
+		return &keyError{key: key, category: category, err: err}
 	}
+	deduplicateLog(keyPrefix(key, c.cfg.NamespaceSeparator), "WARN: Failed to process key %s (%v error), continuing: %v", key, category, err)
+	c.metrics.Count(metricKeysError, 1, map[string]string{"category": category.String()})
 
-	// 删除备份文件
-	err = os.Remove(backupFilePath)
+	c.failedMu.Lock()
+	c.failedKeys = append(c.failedKeys, key)
+	c.failedMu.Unlock()
+	return nil
+}
 
-	return err
+// 输出本次清理失败的键数，设置 --errors-file 时将这些键写入文件，留到下一次清理最先处理
+func (c *Cleaner) saveFailedKeys() {
+	c.failedMu.Lock()
+	keys := c.failedKeys
+	c.failedKeys = nil
+	c.failedMu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	path := c.errorsFilePath()
+	if path == "" {
+		log.Printf("WARN: %d keys failed during lazy deletion", len(keys))
+		return
+	}
+	// 与过期键文件相同，开启 --key-file-encrypt 时加密写入
+	var frames []byte
+	for _, key := range keys {
+		frames = append(frames, frameRecord(key, formatText)...)
+	}
+	if err := appendFramesToFile(path, sealFrames(frames)); err != nil {
+		log.Printf("Failed to write failed keys to %s: %v", path, err)
+		return
+	}
+	log.Printf("WARN: %d keys failed during lazy deletion, saved to %s for the next cleanup", len(keys), path)
 }
 
-func copyFile(srcPath, destPath string) error {
-	// 打开源文件
-	srcFile, err := os.Open(srcPath)
+// 读取并删除上次清理写入 --errors-file 的键，未设置或文件不存在时返回空
+func (c *Cleaner) takeFailedKeys() ([]string, error) {
+	path := c.errorsFilePath()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if data, err = unsealFrames(data); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, key := range strings.Split(string(data), "\n") {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 {
+		log.Printf("Retrying %d keys that failed in the previous cleanup", len(keys))
 	}
-	defer srcFile.Close()
+	return keys, os.Remove(path)
+}
+
+// 失败键文件路径，按数据库分文件时每个数据库各一个 (<errors-file>.N)
+func (c *Cleaner) errorsFilePath() string {
+	if c.cfg.ErrorsFile == "" || !c.cfg.PerDBFiles {
+		return c.cfg.ErrorsFile
+	}
+	return fmt.Sprintf("%s.%d", c.cfg.ErrorsFile, c.rdb.Options().DB)
+}
 
-	// 创建目标文件
+// 记录审计日志，写入失败只打印日志不影响清理
+func (c *Cleaner) recordAudit(key string, db int, outcome string, latency time.Duration) {
+	if err := c.audit.Record(key, db, strings.ToUpper(c.cfg.DeletionStrategy), outcome, latency); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// 将已加锁的 srcFile 中的记录去重后写入 destPath
+func copyFile(srcFile *os.File, destPath, format string) error {
+	// 创建目标文件，扩展名为 .gz 时使用 gzip 压缩，.zst 时使用 zstd 压缩。
+	// 先压缩再加密 (--key-file-encrypt)，密文无法压缩
 	destFile, err := os.Create(destPath)
 	if err != nil {
 		return err
 	}
 	defer destFile.Close()
+	sealer := newSealWriter(destFile, keyFileCipher.Load())
+
+	var writer io.WriteCloser
+	switch {
+	case strings.HasSuffix(destPath, ".gz"):
+		writer = gzip.NewWriter(sealer)
+	case strings.HasSuffix(destPath, ".zst"):
+		writer = newZstdWriter(sealer)
+	}
+	if writer == nil {
+		if err := copyRecords(sealer, srcFile, format); err != nil {
+			return err
+		}
+		return sealer.Close()
+	}
+
+	if err := copyRecords(writer, srcFile, format); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return sealer.Close()
+}
 
-	// 使用一个 map 来去重
-	seen := make(map[string]struct{})
+// 将 src 中的记录按键名去重后以明文写入 dest，跳过无法解析和超长的记录。
+// src 开启 --key-file-encrypt 时逐块解密，需要加密时由调用方包装 dest
+func copyRecords(dest io.Writer, src io.Reader, format string) error {
+	// 按 --key-dedup-algorithm 选择的集合按键名去重
+	seen, err := newKeySet(keyDedupAlgorithm, expectedRecords(src))
+	if err != nil {
+		return err
+	}
+	var frames []byte
 
 	// 使用 bufio.Scanner 逐行读取源文件
-	scanner := bufio.NewScanner(srcFile)
+	scanner := newRecordScanner(src, format)
 	for scanner.Scan() {
 		line := scanner.Text()
+		rec, ok := parseRecord(line, format)
+		if !ok {
+			continue
+		}
+		if keyTooLong(rec.Key) {
+			continue
+		}
 
-		// 如果这行内容没有出现过，则写入目标文件
-		if _, ok := seen[line]; !ok {
-			seen[line] = struct{}{}
-			_, err := destFile.WriteString(line + "\n")
-			if err != nil {
-				return err
+		// 如果这个键没有出现过，则写入目标文件
+		if seen.Add(rec.Key) {
+			frames = append(frames, frameRecord(line, format)...)
+			if len(frames) >= encryptChunkSize {
+				if _, err := dest.Write(frames); err != nil {
+					return err
+				}
+				frames = frames[:0]
 			}
 		}
 	}
@@ -220,6 +1703,61 @@ func copyFile(srcPath, destPath string) error {
 	if err := scanner.Err(); err != nil {
 		return err
 	}
+	_, err = dest.Write(frames)
+	return err
+}
 
-	return nil
+// gzip 解压读取器，关闭时同时关闭底层文件
+type gzipFileReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *gzipFileReader) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+// 解密读取器，关闭时关闭底层文件
+type decryptedFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (r *decryptedFile) Close() error {
+	return r.file.Close()
+}
+
+// 打开备份文件，返回明文记录：开启 --key-file-encrypt 时先解密，扩展名为 .gz 或 .zst 时再解压
+func openBackupFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	plain := decryptReader(file)
+	if strings.HasSuffix(path, ".zst") {
+		r, err := newZstdFileReader(plain, file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return &decryptedFile{Reader: plain, file: file}, nil
+	}
+
+	gz, err := gzip.NewReader(plain)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &gzipFileReader{Reader: gz, file: file}, nil
+}
+
+// 仅在开启调试日志时输出
+func debugf(format string, v ...interface{}) {
+	if debugLogging {
+		log.Printf("DEBUG: "+format, v...)
+	}
 }
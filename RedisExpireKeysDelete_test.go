@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/RESIDUALWASTE/RedisExpireKeysDelete/internal/testutil"
+)
+
+// 创建使用 FakeRedisClient 和 strategy 的 Cleaner，过期键文件位于临时目录，预先写入 keys
+func newTestCleaner(t *testing.T, rdb RedisClient, strategy string, keys ...string) *Cleaner {
+	t.Helper()
+	s, err := newDeletionStrategy(strategy)
+	if err != nil {
+		t.Fatalf("newDeletionStrategy(%q): %v", strategy, err)
+	}
+	cfg := &Config{KeyFile: filepath.Join(t.TempDir(), "expired_keys.txt"), Format: formatText, DeletionStrategy: strategy}
+	store := NewFileKeyStore(cfg.KeyFile, cfg.Format)
+	for _, key := range keys {
+		if err := store.Append(KeyRecord{Key: key}); err != nil {
+			t.Fatalf("Append %s: %v", key, err)
+		}
+	}
+	return &Cleaner{rdb: rdb, store: store, cfg: cfg, strategy: s, lastStats: newCleanupStats()}
+}
+
+func TestPerformLazyDelete(t *testing.T) {
+	noperm := errors.New("NOPERM no permissions")
+	tests := []struct {
+		name      string
+		keys      []string
+		setup     func(f *testutil.FakeRedisClient)
+		filter    *keyFilter
+		wantStats [3]int // processed, deleted, present
+		wantCalls []string
+	}{
+		{
+			// 未配置的键视为不存在，TYPE 返回 none
+			name:      "key not found",
+			keys:      []string{"session:1", "session:2"},
+			wantStats: [3]int{2, 2, 0},
+			wantCalls: []string{"type session:1", "type session:2"},
+		},
+		{
+			name:      "re-created key",
+			keys:      []string{"session:1", "session:2"},
+			setup:     func(f *testutil.FakeRedisClient) { f.AddTypeResponse("session:2", "string") },
+			wantStats: [3]int{2, 1, 1},
+			wantCalls: []string{"type session:1", "type session:2"},
+		},
+		{
+			// 权限错误可以跳过，记为错误后继续处理下一个键
+			name:      "skippable error",
+			keys:      []string{"session:1", "session:2"},
+			setup:     func(f *testutil.FakeRedisClient) { f.ForceError("type", "session:1", noperm) },
+			wantStats: [3]int{2, 1, 0},
+			wantCalls: []string{"type session:1", "type session:2"},
+		},
+		{
+			name:      "filtered key",
+			keys:      []string{"session:1", "lock:1"},
+			filter:    &keyFilter{blacklist: []string{"lock:*"}},
+			wantStats: [3]int{1, 1, 0},
+			wantCalls: []string{"type session:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := testutil.NewFakeRedisClient(0)
+			if tt.setup != nil {
+				tt.setup(rdb)
+			}
+			c := newTestCleaner(t, rdb, strategyType, tt.keys...)
+			c.filter = tt.filter
+
+			if err := c.performLazyDelete(context.Background(), c.strategy); err != nil {
+				t.Fatalf("performLazyDelete() error = %v", err)
+			}
+			stats := c.lastStats
+			if got := [3]int{stats.processed, stats.deleted, stats.present}; got != tt.wantStats {
+				t.Errorf("stats (processed, deleted, present) = %v, want %v", got, tt.wantStats)
+			}
+			if calls := rdb.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", calls, tt.wantCalls)
+			}
+			if data, err := os.ReadFile(c.store.Path()); err != nil || len(data) != 0 {
+				t.Errorf("key file after cleanup = %q (err %v), want empty", data, err)
+			}
+		})
+	}
+}
+
+// ctx 已取消时不访问任何键，所有键写回过期键文件
+func TestPerformLazyDeleteCancelled(t *testing.T) {
+	rdb := testutil.NewFakeRedisClient(0)
+	c := newTestCleaner(t, rdb, strategyType, "session:1", "session:2")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.performLazyDelete(ctx, c.strategy); err != nil {
+		t.Fatalf("performLazyDelete() error = %v", err)
+	}
+	if calls := rdb.Calls(); len(calls) != 0 {
+		t.Errorf("calls = %q, want none", calls)
+	}
+	data, err := os.ReadFile(c.store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(data)); !reflect.DeepEqual(got, []string{"session:1", "session:2"}) {
+		t.Errorf("key file after cancelled cleanup = %q, want both keys requeued", got)
+	}
+}
+
+// JSON 格式中断时写回原始记录，ts、db 和 meta 不丢失
+func TestPerformLazyDeleteCancelledKeepsRecords(t *testing.T) {
+	rdb := testutil.NewFakeRedisClient(0)
+	c := newTestCleaner(t, rdb, strategyType)
+	c.cfg.Format = formatJSON
+	c.store = NewFileKeyStore(c.cfg.KeyFile, formatJSON)
+	want := []KeyRecord{
+		{Key: "session:1", DB: 3, TS: "2024-05-01T00:00:00Z", Meta: map[string]string{"user": "42"}},
+		{Key: "session:2", DB: 3, TS: "2024-05-01T00:00:01Z"},
+	}
+	for _, rec := range want {
+		if err := c.store.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.performLazyDelete(ctx, c.strategy); err != nil {
+		t.Fatalf("performLazyDelete() error = %v", err)
+	}
+	var got []KeyRecord
+	if err := c.store.eachRecord(func(rec KeyRecord, _ string) { got = append(got, rec) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requeued records = %+v, want %+v", got, want)
+	}
+}
+
+// 默认 (--keep-backups=0) 清理成功后删除备份文件，大于 0 时保留
+func TestPerformLazyDeleteKeepBackups(t *testing.T) {
+	for _, keep := range []int{0, 1} {
+		c := newTestCleaner(t, testutil.NewFakeRedisClient(0), strategyType, "session:1")
+		c.cfg.KeepBackups = keep
+		if err := c.performLazyDelete(context.Background(), c.strategy); err != nil {
+			t.Fatalf("performLazyDelete() error = %v", err)
+		}
+		_, err := os.Stat(backupPath(c.cfg.KeyFile, 1, compressionNone))
+		if kept := err == nil; kept != (keep > 0) {
+			t.Errorf("--keep-backups=%d: backup kept = %v (err %v)", keep, kept, err)
+		}
+	}
+}
+
+func TestTouchKey(t *testing.T) {
+	refused := errors.New("connection refused")
+	tests := []struct {
+		name        string
+		strategy    string
+		verify      bool
+		setup       func(f *testutil.FakeRedisClient)
+		wantErr     bool
+		wantOutcome string
+		wantCalls   []string
+	}{
+		{
+			name:        "type",
+			strategy:    strategyType,
+			wantOutcome: outcomeDeleted,
+			wantCalls:   []string{"type k"},
+		},
+		{
+			name:        "del with verify",
+			strategy:    strategyDel,
+			verify:      true,
+			wantOutcome: outcomeDeleted,
+			wantCalls:   []string{"del k", "exists k"},
+		},
+		{
+			// 键仍然存在时不需要确认删除
+			name:        "present key is not verified",
+			strategy:    strategyExists,
+			verify:      true,
+			setup:       func(f *testutil.FakeRedisClient) { f.AddTypeResponse("k", "string") },
+			wantOutcome: outcomePresent,
+			wantCalls:   []string{"exists k"},
+		},
+		{
+			name:        "transient error is retried",
+			strategy:    strategyUnlink,
+			setup:       func(f *testutil.FakeRedisClient) { f.ForceError("unlink", "k", refused) },
+			wantErr:     true,
+			wantOutcome: outcomeError,
+			wantCalls:   []string{"unlink k", "unlink k", "unlink k", "unlink k"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := testutil.NewFakeRedisClient(0)
+			if tt.setup != nil {
+				tt.setup(rdb)
+			}
+			c := newTestCleaner(t, rdb, tt.strategy)
+			c.cfg.VerifyAfterDelete = tt.verify
+			stats := newCleanupStats()
+
+			err := c.touchKey(context.Background(), "k", 0, "file", c.strategy, stats)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("touchKey() error = %v, want error %v", err, tt.wantErr)
+			}
+			var outcome string
+			switch {
+			case stats.deleted == 1:
+				outcome = outcomeDeleted
+			case stats.present == 1:
+				outcome = outcomePresent
+			case stats.errors == 1:
+				outcome = outcomeError
+			}
+			if stats.processed != 1 || outcome != tt.wantOutcome {
+				t.Errorf("recorded outcome = %q (processed %d), want %q", outcome, stats.processed, tt.wantOutcome)
+			}
+			if calls := rdb.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestProcessKeysFailedKey(t *testing.T) {
+	refused := errors.New("connection refused")
+	tests := []struct {
+		name          string
+		ignoreErrors  bool
+		wantProcessed int
+		wantErr       bool
+		wantFailed    []string
+	}{
+		// 默认在失败的键处中止，后面的键不再处理
+		{name: "abort", wantProcessed: 1, wantErr: true},
+		{name: "ignore errors", ignoreErrors: true, wantProcessed: 3, wantFailed: []string{"session:2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := testutil.NewFakeRedisClient(0)
+			rdb.ForceError("type", "session:2", refused)
+			c := newTestCleaner(t, rdb, strategyType)
+			c.cfg.IgnoreErrors = tt.ignoreErrors
+			stats := newCleanupStats()
+
+			keys := []string{"session:1", "session:2", "session:3"}
+			processed, err := c.processKeys(context.Background(), keys, 0, c.strategy, stats)
+			if processed != tt.wantProcessed {
+				t.Errorf("processKeys() processed %d keys, want %d", processed, tt.wantProcessed)
+			}
+			if _, ok := err.(*keyError); ok != tt.wantErr {
+				t.Errorf("processKeys() error = %v, want *keyError %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(c.failedKeys, tt.wantFailed) {
+				t.Errorf("failed keys = %q, want %q", c.failedKeys, tt.wantFailed)
+			}
+			if stats.errors != 1 || !strings.Contains(stats.String(), "errors=1") {
+				t.Errorf("stats = %v, want errors=1", stats)
+			}
+		})
+	}
+}
+
+// 未开启 --ignore-errors 时失败的键和之后的键写回过期键文件，错误交给 --exit-on-cleanup-error 处理
+func TestRunCleanupKeyFailure(t *testing.T) {
+	rdb := testutil.NewFakeRedisClient(0)
+	rdb.ForceError("type", "session:2", errors.New("connection refused"))
+	c := newTestCleaner(t, rdb, strategyType, "session:1", "session:2", "session:3")
+	c.cfg.ExitOnCleanupError = true
+
+	cleanupErr := c.runCleanup(context.Background())
+	if _, ok := cleanupErr.(*keyError); !ok {
+		t.Fatalf("runCleanup() error = %v, want *keyError", cleanupErr)
+	}
+	data, err := os.ReadFile(c.store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(data)); !reflect.DeepEqual(got, []string{"session:2", "session:3"}) {
+		t.Errorf("key file after the failed cleanup = %q, want the failed and unprocessed keys", got)
+	}
+	if c.cleanupError(cleanupErr) == nil {
+		t.Error("cleanupError() = nil with --exit-on-cleanup-error")
+	}
+	c.cfg.ExitOnCleanupError = false
+	if got := c.cleanupError(cleanupErr); got != nil {
+		t.Errorf("cleanupError() = %v without --exit-on-cleanup-error, want nil", got)
+	}
+}
+
+// 每个键的处理日志是调试日志，内容是实际使用的删除策略和结果
+func TestTouchKeyLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	rdb := testutil.NewFakeRedisClient(0)
+	rdb.AddTypeResponse("k", "string")
+	c := newTestCleaner(t, rdb, strategyExists)
+	if err := c.touchKey(context.Background(), "k", 0, "scan", c.strategy, newCleanupStats()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("touchKey logged %q without --debug", buf.String())
+	}
+
+	debugLogging = true
+	t.Cleanup(func() { debugLogging = false })
+	if err := c.touchKey(context.Background(), "k", 0, "scan", c.strategy, newCleanupStats()); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "EXISTS key k: present source=scan") || strings.Contains(out, "\n\n") {
+		t.Errorf("debug log = %q, want the strategy and outcome on one line", out)
+	}
+}
+
+// pipeline 出错时孤儿键扫描返回错误，不访问扫描到的键
+func TestScanOrphansPipelineError(t *testing.T) {
+	rdb := testutil.NewFakeRedisClient(0)
+	rdb.AddTypeResponse("session:1", "string")
+	broken := errors.New("pipeline broken")
+	rdb.ForceError("pipeline", "", broken)
+	c := newTestCleaner(t, rdb, strategyType)
+
+	if err := c.scanOrphans(context.Background()); err != broken {
+		t.Errorf("scanOrphans() error = %v, want %v", err, broken)
+	}
+	if calls := rdb.Calls(); !reflect.DeepEqual(calls, []string{"scan *", "pipeline "}) {
+		t.Errorf("calls = %q, want only SCAN and the pipeline", calls)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// requiredCommands 返回按当前配置需要的命令，子命令写作 config|get
+func requiredCommands(cfg *Config) []string {
+	commands := []string{"type"}
+	if cfg.CompatMode != "scan" {
+		commands = append(commands, "subscribe", "psubscribe")
+		if !cfg.NoKeyspaceEventSetup {
+			commands = append(commands, "config|get", "config|set")
+		}
+	}
+	switch cfg.DeletionStrategy {
+	case strategyExists:
+		commands = append(commands, "exists")
+	case strategyDel:
+		commands = append(commands, "del")
+	case strategyUnlink:
+		commands = append(commands, "unlink")
+	case strategyScript:
+		commands = append(commands, "evalsha", "script|load")
+	}
+	if cfg.TypeHandlerConfig != "" {
+		commands = append(commands, "hdel")
+	}
+	if cfg.ExportBeforeExpiry {
+		commands = append(commands, "pttl", "dump")
+	}
+	if cfg.PubsubHealthInterval > 0 {
+		commands = append(commands, "set", "exists")
+	}
+	return commands
+}
+
+// checkACLPermissions 通过 ACL WHOAMI 和 ACL GETUSER 确认当前用户可以执行 commands，
+// 缺少权限时直接退出。Redis 6 以下或无法读取 ACL 时只输出告警
+func checkACLPermissions(ctx context.Context, rdb *redis.Client, commands []string) {
+	user, err := rdb.Do(ctx, "ACL", "WHOAMI").Text()
+	if err != nil {
+		log.Printf("WARN: Failed to get current ACL user, skipping permission check: %v", err)
+		return
+	}
+	reply, err := rdb.Do(ctx, "ACL", "GETUSER", user).Slice()
+	if err != nil {
+		log.Printf("WARN: Failed to get ACL rules of user %s, skipping permission check: %v", user, err)
+		return
+	}
+
+	// 回复是 flags、passwords、commands 等字段名和值交替组成的数组
+	var rules string
+	for i := 0; i+1 < len(reply); i += 2 {
+		if name, _ := reply[i].(string); name == "commands" {
+			rules, _ = reply[i+1].(string)
+		}
+	}
+
+	acl := &aclRules{rdb: rdb, rules: strings.Fields(rules), categories: make(map[string]map[string]bool)}
+	var missing []string
+	for _, command := range commands {
+		allowed, err := acl.allows(ctx, command)
+		if err != nil {
+			log.Printf("WARN: Failed to check ACL permission for %s: %v", command, err)
+			continue
+		}
+		if !allowed {
+			missing = append(missing, command)
+		}
+	}
+	if len(missing) > 0 {
+		log.Fatalf("ACL user %s is missing required permissions: %s (rules: %s)", user, strings.Join(missing, ", "), rules)
+	}
+	debugf("ACL user %s has all required permissions: %s", user, strings.Join(commands, ", "))
+}
+
+// aclRules 按 ACL GETUSER 返回的命令规则 (例如 "-@all +type +config|get") 判断命令是否被允许，
+// 规则从左到右依次生效。@category 的成员通过 ACL CAT 查询
+type aclRules struct {
+	rdb        *redis.Client
+	rules      []string
+	categories map[string]map[string]bool
+}
+
+func (a *aclRules) allows(ctx context.Context, command string) (bool, error) {
+	base := strings.SplitN(command, "|", 2)[0]
+	allowed := false
+	for _, rule := range a.rules {
+		switch rule {
+		case "allcommands":
+			allowed = true
+			continue
+		case "nocommands":
+			allowed = false
+			continue
+		}
+		if len(rule) < 2 || (rule[0] != '+' && rule[0] != '-') {
+			continue
+		}
+		grant, target := rule[0] == '+', strings.ToLower(rule[1:])
+
+		match := target == base || target == command
+		if strings.HasPrefix(target, "@") {
+			var err error
+			if match, err = a.inCategory(ctx, target[1:], base); err != nil {
+				return false, err
+			}
+		}
+		if match {
+			allowed = grant
+		}
+	}
+	return allowed, nil
+}
+
+// 判断命令是否属于 ACL 分类，@all 包含所有命令
+func (a *aclRules) inCategory(ctx context.Context, category, command string) (bool, error) {
+	if category == "all" {
+		return true, nil
+	}
+	members, ok := a.categories[category]
+	if !ok {
+		names, err := a.rdb.Do(ctx, "ACL", "CAT", category).StringSlice()
+		if err != nil {
+			return false, fmt.Errorf("ACL CAT %s: %v", category, err)
+		}
+		members = make(map[string]bool, len(names))
+		for _, name := range names {
+			members[strings.ToLower(name)] = true
+		}
+		a.categories[category] = members
+	}
+	return members[command], nil
+}
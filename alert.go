@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// backlogAlerter 在过期键文件的行数超过阈值时向 --alert-webhook-url 发送告警，
+// 发送后进入 --alert-cooldown 冷却期，期间不再重复发送
+type backlogAlerter struct {
+	url        string
+	threshold  int64
+	cooldown   time.Duration
+	client     *http.Client
+	inCooldown atomic.Bool
+}
+
+type backlogAlert struct {
+	Alert     string `json:"alert"`
+	Count     int64  `json:"count"`
+	File      string `json:"file"`
+	Threshold int64  `json:"threshold"`
+	TS        string `json:"ts"`
+}
+
+func newBacklogAlerter(url string, threshold int, cooldown time.Duration) *backlogAlerter {
+	return &backlogAlerter{url: url, threshold: int64(threshold), cooldown: cooldown, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Check 在 count 超过阈值且不在冷却期时异步发送告警，a 为 nil 时不做任何事
+func (a *backlogAlerter) Check(file string, count int64) {
+	if a == nil || count <= a.threshold {
+		return
+	}
+	if !a.inCooldown.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(a.cooldown, func() { a.inCooldown.Store(false) })
+
+	alert := backlogAlert{
+		Alert:     "large_backlog",
+		Count:     count,
+		File:      file,
+		Threshold: a.threshold,
+		TS:        time.Now().Format(time.RFC3339Nano),
+	}
+	go func() {
+		if err := a.send(alert); err != nil {
+			log.Printf("Failed to send large backlog alert: %v", err)
+		}
+	}()
+}
+
+func (a *backlogAlerter) send(alert backlogAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	log.Printf("Sent large backlog alert for %s (%d lines)", alert.File, alert.Count)
+	return nil
+}
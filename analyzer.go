@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyAnalyzer 按键名前缀统计每个时间窗口内的过期数量，
+// 用于发现异常高的过期速率 (例如键被反复创建又过期)
+type KeyAnalyzer struct {
+	separator string
+	counts    sync.Map // 前缀 -> *int64
+}
+
+func NewKeyAnalyzer(separator string) *KeyAnalyzer {
+	return &KeyAnalyzer{separator: separator}
+}
+
+// Observe 记录一次过期事件
+func (a *KeyAnalyzer) Observe(key string) {
+	prefix := keyPrefix(key, a.separator)
+	v, ok := a.counts.Load(prefix)
+	if !ok {
+		v, _ = a.counts.LoadOrStore(prefix, new(int64))
+	}
+	atomic.AddInt64(v.(*int64), 1)
+}
+
+// Run 每个 window 输出一次过期最多的前 10 个前缀并重置计数，直到 ctx 结束
+func (a *KeyAnalyzer) Run(ctx context.Context, window time.Duration) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.report(window)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type prefixCount struct {
+	prefix string
+	count  int64
+}
+
+func (a *KeyAnalyzer) report(window time.Duration) {
+	var top []prefixCount
+	a.counts.Range(func(k, v interface{}) bool {
+		a.counts.Delete(k)
+		top = append(top, prefixCount{prefix: k.(string), count: atomic.LoadInt64(v.(*int64))})
+		return true
+	})
+	if len(top) == 0 {
+		return
+	}
+
+	sort.Slice(top, func(i, j int) bool { return top[i].count > top[j].count })
+	if len(top) > 10 {
+		top = top[:10]
+	}
+
+	parts := make([]string, 0, len(top))
+	for _, pc := range top {
+		parts = append(parts, fmt.Sprintf("%s=%d", pc.prefix, pc.count))
+	}
+	log.Printf("Top expired key prefixes in the last %v: %s", window, strings.Join(parts, " "))
+}
+
+// 键名中第一个分隔符之前的部分，没有分隔符时返回整个键名
+func keyPrefix(key, separator string) string {
+	if i := strings.Index(key, separator); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditLog 以 JSON 行的形式永久记录每个被处理的键
+// 工具本身从不截断或轮转该文件，轮转交给 logrotate 等外部工具，
+// 轮转后发送 SIGHUP 让工具重新打开文件
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+type auditRecord struct {
+	TS        string `json:"ts"`
+	Key       string `json:"key"`
+	DB        int    `json:"db"`
+	Action    string `json:"action"`
+	Outcome   string `json:"outcome"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// OpenAuditLog 以追加 + 同步写的方式打开审计日志
+func OpenAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{path: path}
+	if err := a.Reopen(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reopen 关闭并重新打开审计日志文件
+func (a *AuditLog) Reopen() error {
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY|os.O_SYNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.file = file
+	return nil
+}
+
+// Record 追加一条审计记录，a 为 nil 时不做任何事
+func (a *AuditLog) Record(key string, db int, action, outcome string, latency time.Duration) error {
+	if a == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(auditRecord{
+		TS:        time.Now().Format(time.RFC3339Nano),
+		Key:       key,
+		DB:        db,
+		Action:    action,
+		Outcome:   outcome,
+		LatencyMS: latency.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close 关闭审计日志文件
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
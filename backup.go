@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 备份文件的压缩方式 (--key-file-compression)，按扩展名区分，读取时自动识别
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip" // <file>.bak.N.gz
+	compressionZstd = "zstd" // <file>.bak.N.zst，压缩率比 gzip 高，速度相近
+)
+
+// 压缩方式对应的扩展名
+func compressionExt(compression string) string {
+	switch compression {
+	case compressionGzip:
+		return ".gz"
+	case compressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// 第 n 个备份文件的路径：<file>.bak.N，压缩时加上 .gz 或 .zst
+func backupPath(filePath string, n int, compression string) string {
+	return fmt.Sprintf("%s.bak.%d", filePath, n) + compressionExt(compression)
+}
+
+// 轮转备份文件：.bak.N-1 -> .bak.N，超出保留数量的最旧备份被删除，
+// 轮转后 .bak.1 空出来留给本次清理
+func rotateBackups(filePath string, keep int, compression string) error {
+	if keep < 1 {
+		keep = 1
+	}
+	if err := os.Remove(backupPath(filePath, keep, compression)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := keep - 1; n >= 1; n-- {
+		err := os.Rename(backupPath(filePath, n, compression), backupPath(filePath, n+1, compression))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// zstd 的编码器和解码器创建开销较大 (内部缓冲区和 goroutine)，在多次备份之间复用。
+// 按数据库分文件时多个清理可能同时写备份，用 sync.Pool 避免共享同一个实例
+var (
+	zstdEncoders = sync.Pool{New: func() interface{} {
+		enc, _ := zstd.NewWriter(nil)
+		return enc
+	}}
+	zstdDecoders = sync.Pool{New: func() interface{} {
+		dec, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return dec
+	}}
+)
+
+// zstd 压缩写入器，关闭时写完剩余数据并归还编码器，不关闭底层文件
+type zstdWriter struct {
+	*zstd.Encoder
+}
+
+func newZstdWriter(w io.Writer) *zstdWriter {
+	enc := zstdEncoders.Get().(*zstd.Encoder)
+	enc.Reset(w)
+	return &zstdWriter{Encoder: enc}
+}
+
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	zstdEncoders.Put(w.Encoder)
+	return err
+}
+
+// zstd 解压读取器，关闭时归还解码器并关闭底层文件
+type zstdFileReader struct {
+	*zstd.Decoder
+	file *os.File
+}
+
+// 从 r 解压读取，关闭时关闭 file (r 可以是 file 本身或 file 的解密读取器)
+func newZstdFileReader(r io.Reader, file *os.File) (*zstdFileReader, error) {
+	dec := zstdDecoders.Get().(*zstd.Decoder)
+	if err := dec.Reset(r); err != nil {
+		zstdDecoders.Put(dec)
+		return nil, err
+	}
+	return &zstdFileReader{Decoder: dec, file: file}, nil
+}
+
+func (r *zstdFileReader) Close() error {
+	r.Decoder.Reset(nil)
+	zstdDecoders.Put(r.Decoder)
+	return r.file.Close()
+}
@@ -0,0 +1,97 @@
+package main
+
+import "sync"
+
+// 保留最近的事件数量，供断线重连的 SSE 客户端补齐
+const brokerHistorySize = 1000
+
+// brokerEvent 是带有递增序号的过期事件
+type brokerEvent struct {
+	ID     uint64
+	Record KeyRecord
+}
+
+// eventBroker 将过期事件广播给所有实时订阅者 (gRPC、WebSocket、SSE)，
+// 订阅者处理过慢、缓冲已满时丢弃该订阅者的事件，不阻塞事件处理
+type eventBroker struct {
+	mu      sync.Mutex
+	subs    map[chan brokerEvent]struct{}
+	history []brokerEvent // 环形缓冲区，长度固定为 brokerHistorySize
+	head    int           // 下一个写入位置，缓冲区写满后也是最旧事件的位置
+	count   int           // 缓冲区中的事件数量
+	nextID  uint64
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subs:    make(map[chan brokerEvent]struct{}),
+		history: make([]brokerEvent, brokerHistorySize),
+		nextID:  1,
+	}
+}
+
+// Subscribe 返回接收之后所有事件的 channel
+func (b *eventBroker) Subscribe(buffer int) chan brokerEvent {
+	ch, _ := b.SubscribeFrom(0, buffer)
+	return ch
+}
+
+// SubscribeFrom 订阅之后的事件，同时返回缓冲区中序号大于 lastID 的历史事件，
+// lastID 为 0 时不返回历史事件
+func (b *eventBroker) SubscribeFrom(lastID uint64, buffer int) (chan brokerEvent, []brokerEvent) {
+	ch := make(chan brokerEvent, buffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var missed []brokerEvent
+	if lastID > 0 {
+		// 从最旧的事件开始按序号顺序遍历
+		start := b.head - b.count
+		if start < 0 {
+			start += len(b.history)
+		}
+		for i := 0; i < b.count; i++ {
+			ev := b.history[(start+i)%len(b.history)]
+			if ev.ID > lastID {
+				missed = append(missed, ev)
+			}
+		}
+	}
+	b.subs[ch] = struct{}{}
+	return ch, missed
+}
+
+// Unsubscribe 取消订阅并关闭 channel
+func (b *eventBroker) Unsubscribe(ch chan brokerEvent) {
+	b.mu.Lock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+	b.mu.Unlock()
+}
+
+// Publish 广播一个事件，b 为 nil 时不做任何事
+func (b *eventBroker) Publish(rec KeyRecord) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := brokerEvent{ID: b.nextID, Record: rec}
+	b.nextID++
+	b.history[b.head] = ev
+	b.head = (b.head + 1) % len(b.history)
+	if b.count < len(b.history) {
+		b.count++
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 清理锁的实现 (--lock-backend)
+const (
+	lockBackendNone  = "none"
+	lockBackendFile  = "file"  // 本机 flock，Redis 不可用时也能工作，只能防止同一台机器上的多个实例
+	lockBackendRedis = "redis" // SET NX PX，跨主机生效，但 Redis 不可用时无法加锁
+)
+
+// cleanupLocker 保证同一时刻只有一个实例在清理同一个过期键文件
+type cleanupLocker interface {
+	// TryLock 尝试获取名为 name 的锁，已被其他实例持有时返回 false，不等待
+	TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error)
+}
+
+// newCleanupLocker 根据 --lock-backend 创建清理锁，none 时返回 nil
+func newCleanupLocker(cfg *Config, rdb *redis.Client) (cleanupLocker, error) {
+	switch cfg.LockBackend {
+	case lockBackendNone:
+		return nil, nil
+	case lockBackendFile:
+		return fileLocker{path: cfg.CleanupLockFile}, nil
+	case lockBackendRedis:
+		if cfg.CleanupLockTTL <= 0 {
+			return nil, fmt.Errorf("--cleanup-lock-ttl must be positive, got %v", cfg.CleanupLockTTL)
+		}
+		return redisLocker{rdb: rdb, key: cfg.CleanupLockKey, ttl: cfg.CleanupLockTTL}, nil
+	}
+	return nil, fmt.Errorf("unknown lock backend %q (expected file, redis or none)", cfg.LockBackend)
+}
+
+// fileLocker 对本地文件加非阻塞的排他 flock，进程退出时锁自动释放
+type fileLocker struct {
+	path string
+}
+
+func (l fileLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	path := l.path
+	if name != "" {
+		path += "." + name
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	ok, err := tryLockFile(file)
+	if err != nil || !ok {
+		file.Close()
+		return nil, false, err
+	}
+	return func() {
+		unlockFileAlways(file)
+		file.Close()
+	}, true, nil
+}
+
+// 只在锁的值仍是自己的 token 时删除，避免删除超时后被其他实例获取的锁
+const releaseLockScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+else
+	return 0
+end`
+
+// 只在锁的值仍是自己的 token 时续期，锁已过期或被其他实例获取时返回 0
+const extendLockScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+else
+	return 0
+end`
+
+// redisLocker 使用 SET key token NX PX ttl 实现跨主机的锁，持有期间每 ttl/3 续期一次，
+// 持有锁的实例崩溃后 ttl 到期自动释放
+type redisLocker struct {
+	rdb *redis.Client
+	key string
+	ttl time.Duration
+}
+
+func (l redisLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	key := l.key
+	if name != "" {
+		key += ":" + name
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, false, err
+	}
+	token := hex.EncodeToString(buf)
+
+	ok, err := l.rdb.SetNX(ctx, key, token, l.ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.renew(key, token, stop)
+	}()
+	return func() {
+		close(stop)
+		<-done
+		// 清理可能因 ctx 被取消而结束，释放锁使用独立的 ctx
+		if err := l.rdb.Eval(context.Background(), releaseLockScript, []string{key}, token).Err(); err != nil {
+			log.Printf("Failed to release cleanup lock %s: %v", key, err)
+		}
+	}, true, nil
+}
+
+// renew 在 stop 关闭前定期延长锁的过期时间，清理耗时超过 ttl 时锁也不会被其他实例获取
+func (l redisLocker) renew(key, token string, stop <-chan struct{}) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		n, err := l.rdb.Eval(context.Background(), extendLockScript, []string{key}, token, l.ttl.Milliseconds()).Int()
+		if err != nil {
+			log.Printf("Failed to extend cleanup lock %s: %v", key, err)
+			continue
+		}
+		if n == 0 {
+			log.Printf("WARN: Cleanup lock %s was lost, another instance may be cleaning concurrently", key)
+			return
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 检查 --channel-pattern 最多只包含一个 %d，且没有其他格式化动词
+func validateChannelPattern(pattern string) error {
+	verbs := strings.Count(pattern, "%")
+	if verbs != strings.Count(pattern, "%d") || verbs > 1 {
+		return fmt.Errorf("%q must contain at most one %%d verb", pattern)
+	}
+	return nil
+}
+
+// 将数据库编号代入频道模板，模板不含 %d 时原样返回
+func channelName(pattern string, db int) string {
+	if !strings.Contains(pattern, "%d") {
+		return pattern
+	}
+	return fmt.Sprintf(pattern, db)
+}
+
+// 从 __keyevent@<db>__:<event> 形式的频道名中解析事件名，例如 expired、del
+func eventName(channel string) string {
+	if i := strings.Index(channel, "__:"); i >= 0 {
+		return channel[i+len("__:"):]
+	}
+	return ""
+}
+
+// 解析 --patterns 中逗号分隔的订阅模式
+func splitPatterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// 判断键名是否超过 --max-key-len，超过时输出带前 100 字节键名的告警
+func keyTooLong(key string) bool {
+	if maxKeyLen <= 0 || len(key) <= maxKeyLen {
+		return false
+	}
+	prefix := key
+	if len(prefix) > 100 {
+		prefix = prefix[:100]
+	}
+	log.Printf("WARN: Skipping key of %d bytes (limit %d): %q...", len(key), maxKeyLen, prefix)
+	return true
+}
+
+// ExpiredEvent 表示一次键过期事件
+type ExpiredEvent struct {
+	Channel string // 事件来源频道，例如 __keyevent@0__:expired
+	Key     string // 过期的键名
+	Method  string // 发现过期的方式：keyevent (keyspace 通知) 或 scan
+}
+
+// 过期的发现方式
+const (
+	expireMethodKeyevent = "keyevent"
+	expireMethodScan     = "scan"
+)
+
+// EventCollector 收集过期事件并发送到 events，直到 ctx 结束或事件源关闭
+type EventCollector interface {
+	Collect(ctx context.Context, events chan<- ExpiredEvent) error
+}
+
+// pubsubCollector 通过订阅 keyspace 通知收集过期事件
+type pubsubCollector struct {
+	rdb       *redis.Client
+	patterns  []string
+	pubsub    *redis.PubSub
+	reconnect chan struct{}
+	patternCh chan []string
+
+	// go-redis 内部消息缓冲的容量 (--key-events-buffer-size)，0 使用 go-redis 的默认值 100
+	bufferSize int
+	metrics    *Metrics
+}
+
+// pubsub 缓冲占用率的上报间隔
+const bufferFullnessInterval = 10 * time.Second
+
+// errSubscriptionClosed 表示订阅的消息 channel 被关闭。按连接错误处理，
+// 调用方按 BackoffPolicy 退避后再次调用 Collect 重新订阅
+var errSubscriptionClosed = errors.New("redis: subscription channel closed")
+
+func newPubsubCollector(rdb *redis.Client, patterns []string, pubsub *redis.PubSub, bufferSize int, metrics *Metrics) *pubsubCollector {
+	return &pubsubCollector{rdb: rdb, patterns: patterns, pubsub: pubsub, reconnect: make(chan struct{}, 1), patternCh: make(chan []string, 1), bufferSize: bufferSize, metrics: metrics}
+}
+
+// 返回订阅的消息 channel，按 bufferSize 设置缓冲容量
+func (c *pubsubCollector) channel(pubsub *redis.PubSub) <-chan *redis.Message {
+	if c.bufferSize > 0 {
+		return pubsub.Channel(redis.WithChannelSize(c.bufferSize))
+	}
+	return pubsub.Channel()
+}
+
+// Resubscribe 请求改为订阅 patterns：先建立新的订阅，成功后再关闭旧的订阅，
+// 新的模式列表覆盖尚未处理的请求
+func (c *pubsubCollector) Resubscribe(patterns []string) {
+	for {
+		select {
+		case c.patternCh <- patterns:
+			return
+		default:
+		}
+		select {
+		case <-c.patternCh:
+		default:
+		}
+	}
+}
+
+// Reconnect 请求关闭当前订阅连接并重新订阅，用于处理半开的 TCP 连接
+func (c *pubsubCollector) Reconnect() {
+	select {
+	case c.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+func (c *pubsubCollector) Collect(ctx context.Context, events chan<- ExpiredEvent) error {
+	// 上一次 Collect 返回时已经关闭了订阅，再次调用时先重新订阅
+	if c.pubsub == nil {
+		pubsub := c.rdb.PSubscribe(ctx, c.patterns...)
+		if _, err := pubsub.Receive(ctx); err != nil {
+			pubsub.Close()
+			return err
+		}
+		c.pubsub = pubsub
+		log.Printf("Resubscribed to %v", c.patterns)
+	}
+	defer func() {
+		c.pubsub.Close()
+		c.pubsub = nil
+	}()
+
+	ch := c.channel(c.pubsub)
+	ticker := time.NewTicker(bufferFullnessInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 缓冲占用率持续接近 1 说明处理跟不上，继续积压会导致 go-redis 丢弃消息
+			c.metrics.Gauge(metricPubsubBuffer, float64(len(ch))/float64(cap(ch)), nil)
+		case msg, ok := <-ch:
+			if !ok {
+				return errSubscriptionClosed
+			}
+			if keyTooLong(msg.Payload) {
+				continue
+			}
+			events <- ExpiredEvent{Channel: msg.Channel, Key: msg.Payload, Method: expireMethodKeyevent}
+		case <-c.reconnect:
+			if pubsub := c.subscribe(ctx, c.patterns); pubsub != nil {
+				ch = c.channel(pubsub)
+				log.Printf("Resubscribed to %v", c.patterns)
+			}
+		case patterns := <-c.patternCh:
+			if pubsub := c.subscribe(ctx, patterns); pubsub != nil {
+				ch = c.channel(pubsub)
+				log.Printf("Subscription patterns changed from %v to %v", c.patterns, patterns)
+				c.patterns = patterns
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 建立订阅 patterns 的新连接，成功后关闭旧的连接并返回新的订阅，失败时保留旧的订阅并返回 nil
+func (c *pubsubCollector) subscribe(ctx context.Context, patterns []string) *redis.PubSub {
+	pubsub := c.rdb.PSubscribe(ctx, patterns...)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		log.Printf("WARN: Failed to resubscribe to %v: %v", patterns, err)
+		return nil
+	}
+	c.pubsub.Close()
+	c.pubsub = pubsub
+	return pubsub
+}
+
+// syntheticCollector 按固定速率 (键/秒) 生成虚假的过期事件，
+// 用于在没有真实过期流量的情况下压测文件写入、去重和清理流程
+type syntheticCollector struct {
+	rate    int
+	channel string
+}
+
+func (c *syntheticCollector) Collect(ctx context.Context, events chan<- ExpiredEvent) error {
+	if c.rate <= 0 {
+		return fmt.Errorf("synthetic rate must be positive, got %d", c.rate)
+	}
+
+	// ticker 最小精度为 1ms，速率更高时每次触发生成一批事件，
+	// rate 不是 1000 的整数倍时余数累积到下一次触发，保证每秒生成的总数等于 rate
+	tick := time.Second / time.Duration(c.rate)
+	perTick, base := 1, 1
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+		perTick, base = c.rate, 1000
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	counter, carry := 0, 0
+	for {
+		select {
+		case <-ticker.C:
+			carry += perTick
+			batch := carry / base
+			carry %= base
+			for i := 0; i < batch; i++ {
+				counter++
+				events <- ExpiredEvent{Channel: c.channel, Key: fmt.Sprintf("synthetic:key:%d", counter)}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// scanBasedCollector 用于不支持 keyspace 通知的 Redis 实例：
+// 定期 SCAN 全部键，把 TTL 已经变为 -2 (已过期) 的键作为过期事件发出
+type scanBasedCollector struct {
+	rdb       *redis.Client
+	interval  time.Duration
+	count     int64
+	channel   string
+	skipNoTTL bool
+}
+
+// 根据 SCAN 到的键的 TTL 判断是否需要处理：-2 (已过期) 处理，仍有过期时间的键跳过，
+// -1 (没有过期时间的持久键) 只在关闭 --skip-keys-with-no-ttl 时处理
+func scanCandidate(ttl time.Duration, skipNoTTL bool) bool {
+	switch ttl {
+	case -2:
+		return true
+	case -1:
+		return !skipNoTTL
+	default:
+		return false
+	}
+}
+
+func (c *scanBasedCollector) Collect(ctx context.Context, events chan<- ExpiredEvent) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.scanOnce(ctx, events); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Failed to scan for expired keys: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 完整 SCAN 一遍，批量查询每一批键的 TTL
+func (c *scanBasedCollector) scanOnce(ctx context.Context, events chan<- ExpiredEvent) error {
+	var cursor uint64
+	for {
+		keys, next, err := c.rdb.Scan(ctx, cursor, "*", c.count).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			cmds, err := c.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.TTL(ctx, key)
+				}
+				return nil
+			})
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			for i, cmd := range cmds {
+				if scanCandidate(cmd.(*redis.DurationCmd).Val(), c.skipNoTTL) {
+					events <- ExpiredEvent{Channel: c.channel, Key: keys[i], Method: expireMethodScan}
+				}
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// RedisStreamSource 从 Redis Stream 读取过期事件，事件不会因为工具离线而丢失。
+// 需要由其他组件写入 Stream，约定每条消息包含字段:
+//
+//	key  过期的键名 (必填)
+//	db   键所在的数据库编号 (可选，默认 --db)
+//
+// 例如: XADD expired_events_stream * key session:42 db 0 (RedisStreamSink 写入的格式)
+// 最近读取的消息 ID 保存在 offsetFile 中，重启后从该位置继续读取。
+// 指定 startID 时从该消息 ID 之后开始读取 ("0" 表示从头)，用于重放历史事件
+type RedisStreamSource struct {
+	rdb        *redis.Client
+	stream     string
+	offsetFile string
+	startID    string
+	db         int
+}
+
+func (c *RedisStreamSource) Collect(ctx context.Context, events chan<- ExpiredEvent) error {
+	lastID := "$"
+	if c.startID != "" {
+		lastID = c.startID
+		log.Printf("Replaying stream %s from message %s", c.stream, lastID)
+	} else if data, err := os.ReadFile(c.offsetFile); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		lastID = strings.TrimSpace(string(data))
+		log.Printf("Resuming stream %s from message %s", c.stream, lastID)
+	}
+
+	// 临时错误在这里退避重试，继续从 lastID 读取；其他错误返回给调用方处理
+	backoff := &BackoffPolicy{Initial: time.Second, Max: 30 * time.Second}
+	for {
+		streams, err := c.rdb.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{c.stream, lastID},
+			Count:   100,
+			Block:   5 * time.Second,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if category := classifyError(err); !category.retryable() {
+				return fmt.Errorf("read stream %s: %w", c.stream, err)
+			}
+			delay := backoff.NextDelay()
+			log.Printf("Failed to read stream %s: %v, retrying in %v", c.stream, err, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		backoff.Reset()
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				lastID = msg.ID
+				key, ok := msg.Values["key"].(string)
+				if !ok {
+					log.Printf("Skipping stream message %s without key field", msg.ID)
+					continue
+				}
+				db := strconv.Itoa(c.db)
+				if v, ok := msg.Values["db"].(string); ok {
+					db = v
+				}
+				select {
+				case events <- ExpiredEvent{Channel: "__keyevent@" + db + "__:expired", Key: key}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+
+		// 记录最近读取的消息 ID 以便重启后继续
+		if err := os.WriteFile(c.offsetFile, []byte(lastID+"\n"), 0644); err != nil {
+			log.Printf("Failed to save stream offset: %v", err)
+		}
+	}
+}
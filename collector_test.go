@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// 订阅的 channel 被关闭时 Collect 返回可重试的错误，再次调用 Collect 时重新订阅
+func TestPubsubCollectorSubscriptionClosed(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pubsub := rdb.PSubscribe(ctx, expiredChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		t.Fatalf("PSUBSCRIBE: %v", err)
+	}
+	c := newPubsubCollector(rdb, []string{expiredChannel}, pubsub, 0, nil)
+	events := make(chan ExpiredEvent, 10)
+
+	errs := make(chan error, 1)
+	go func() { errs <- c.Collect(ctx, events) }()
+	pubsub.Close()
+	select {
+	case err := <-errs:
+		if err != errSubscriptionClosed || !classifyError(err).retryable() {
+			t.Fatalf("Collect() error = %v, want retryable %v", err, errSubscriptionClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collect did not return after the subscription was closed")
+	}
+
+	// 新的订阅建立之前发布的消息会丢失 (miniredis 可能仍把旧连接计为订阅者)，收到事件之前反复发布
+	go func() { errs <- c.Collect(ctx, events) }()
+	deadline := time.After(5 * time.Second)
+	for received := false; !received; {
+		mr.Publish(expiredChannel, "session:1")
+		select {
+		case ev := <-events:
+			if ev.Key != "session:1" {
+				t.Errorf("event key = %q, want session:1", ev.Key)
+			}
+			received = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no event after resubscribing")
+		}
+	}
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("Collect() after cancel = %v, want %v", err, context.Canceled)
+	}
+}
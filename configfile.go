@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig 是 --config 指定的 YAML 配置文件，例如
+//
+//	intervals:
+//	  "ratelimit:*": 10ms
+//	  "session:*": 1000ms
+//	  default: 300ms
+type fileConfig struct {
+	Intervals map[string]string `yaml:"intervals"` // 键名模式 -> 删除间隔，default 匹配其余所有键
+}
+
+// IntervalRule 表示匹配 Pattern 的键在清理时使用的删除间隔，Pattern 为空时匹配所有键
+type IntervalRule struct {
+	Pattern  string
+	Interval time.Duration
+}
+
+// 读取 --config 配置文件并填充 cfg.Intervals。没有配置 default 时使用 --interval，
+// 未指定配置文件时所有键都使用 --interval
+func loadConfigFile(cfg *Config) error {
+	var fc fileConfig
+	if cfg.ConfigFile != "" {
+		data, err := os.ReadFile(cfg.ConfigFile)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &fc); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", cfg.ConfigFile, err)
+		}
+	}
+
+	rules, err := parseIntervalRules(fc.Intervals, time.Duration(cfg.Interval)*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	cfg.Intervals = rules
+	return nil
+}
+
+// 解析 intervals 配置，按模式长度从长到短排序，default 规则排在最后
+func parseIntervalRules(intervals map[string]string, fallback time.Duration) ([]IntervalRule, error) {
+	rules := make([]IntervalRule, 0, len(intervals)+1)
+	hasDefault := false
+	for pattern, value := range intervals {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q for %q: %v", value, pattern, err)
+		}
+		if pattern == "default" {
+			pattern = ""
+			hasDefault = true
+		} else if err := validatePattern(pattern); err != nil {
+			return nil, err
+		}
+		rules = append(rules, IntervalRule{Pattern: pattern, Interval: interval})
+	}
+	if !hasDefault {
+		rules = append(rules, IntervalRule{Interval: fallback})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].Pattern) != len(rules[j].Pattern) {
+			return len(rules[i].Pattern) > len(rules[j].Pattern)
+		}
+		return rules[i].Pattern < rules[j].Pattern
+	})
+	return rules, nil
+}
+
+// resolveInterval 返回 key 匹配到的第一条 (最长) 规则的删除间隔，都不匹配时返回 0
+func resolveInterval(key string, intervals []IntervalRule) time.Duration {
+	for _, rule := range intervals {
+		if matchPattern(rule.Pattern, key) {
+			return rule.Interval
+		}
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 去重记录的存储位置
+const (
+	dedupBackendMemory = "memory" // 进程内存，重启后丢失
+	dedupBackendRedis  = "redis"  // Redis 中带过期时间的哨兵键，重启后仍然有效
+)
+
+// deduper 判断过期事件是否在去重窗口内已经写入过，并记录本次写入
+type deduper interface {
+	seenRecently(ctx context.Context, ev ExpiredEvent, now time.Time) bool
+}
+
+// dedupCache 记录最近写入过的键，在写入文件前去重，
+// 条目数超过 maxSize 时先淘汰窗口外的条目，仍然超出则清空
+type dedupCache struct {
+	window  time.Duration
+	maxSize int64
+	seen    sync.Map // 键 -> 最近一次写入时间
+	size    atomic.Int64
+}
+
+func newDedupCache(window time.Duration, maxSize int) *dedupCache {
+	return &dedupCache{window: window, maxSize: int64(maxSize)}
+}
+
+// seenRecently 判断事件是否在窗口内写入过，并记录本次写入。
+// 多个 worker (--concurrent-subscriptions) 同时收到同一个键时只有一个返回 false：
+// 新键由 LoadOrStore 写入，窗口外的旧记录由 CompareAndSwap 刷新
+func (c *dedupCache) seenRecently(ctx context.Context, ev ExpiredEvent, now time.Time) bool {
+	key := ev.Channel + "\x00" + ev.Key
+	for {
+		v, loaded := c.seen.LoadOrStore(key, now)
+		if !loaded {
+			if c.size.Add(1) > c.maxSize {
+				c.evict(now)
+			}
+			return false
+		}
+		if now.Sub(v.(time.Time)) < c.window {
+			return true
+		}
+		if c.seen.CompareAndSwap(key, v, now) {
+			return false
+		}
+		// 其他 worker 已经刷新了记录，或记录刚被淘汰，重新判断
+	}
+}
+
+// 淘汰窗口外的条目，仍然超出容量时清空缓存
+func (c *dedupCache) evict(now time.Time) {
+	c.seen.Range(func(k, v interface{}) bool {
+		if now.Sub(v.(time.Time)) >= c.window {
+			c.seen.Delete(k)
+			c.size.Add(-1)
+		}
+		return true
+	})
+	if c.size.Load() <= c.maxSize {
+		return
+	}
+	c.seen.Range(func(k, _ interface{}) bool {
+		c.seen.Delete(k)
+		c.size.Add(-1)
+		return true
+	})
+}
+
+// redisDedup 将最近写入过的键记录为 Redis 中的哨兵键 <prefix><db>:<key>，
+// 过期时间为去重窗口。哨兵键只写在 --db 中，db 取自事件所在的数据库，
+// 避免不同数据库中的同名键互相去重
+type redisDedup struct {
+	rdb    *redis.Client
+	prefix string
+	window time.Duration
+	db     int // 频道中无法解析出数据库编号时使用
+}
+
+func newRedisDedup(rdb *redis.Client, prefix string, window time.Duration, db int) *redisDedup {
+	return &redisDedup{rdb: rdb, prefix: prefix, window: window, db: db}
+}
+
+// seenRecently 用 SET NX EX 原子地检查并写入哨兵键，Redis 出错时按未写入过处理
+func (d *redisDedup) seenRecently(ctx context.Context, ev ExpiredEvent, now time.Time) bool {
+	db, ok := parseChannelDB(ev.Channel)
+	if !ok {
+		db = d.db
+	}
+	ok, err := d.rdb.SetNX(ctx, d.prefix+strconv.Itoa(db)+":"+ev.Key, 1, d.window).Result()
+	if err != nil {
+		log.Printf("WARN: Failed to set dedup sentinel for key %s: %v", ev.Key, err)
+		return false
+	}
+	return !ok
+}
+
+// 哨兵键本身过期时也会产生过期事件，这些事件需要忽略
+func (d *redisDedup) isSentinel(key string) bool {
+	return strings.HasPrefix(key, d.prefix)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// 基准测试的键数。100M 需要约 12GB 内存 (map 和 robin-hood)，-short 时跳过
+var dedupBenchSizes = []struct {
+	name string
+	n    int
+}{
+	{"1M", 1_000_000},
+	{"10M", 10_000_000},
+	{"100M", 100_000_000},
+}
+
+// 单核 Xeon、5GB 内存的机器上 go test -run '^$' -bench Dedup -short -benchtime 1x 的结果
+// (100M 超出该机器的内存，未测量):
+//
+//	                ns/key   MB      B/key   false-dup-%
+//	map/1M          367      68.6    71.9    0
+//	map/10M         538      579.2   60.7    0
+//	bloom/1M        175      1.1     1.2     0.17
+//	bloom/10M       366      11.4    1.2     0.17
+//	robin-hood/1M   290      79.3    83.1    0
+//	robin-hood/10M  331      664.6   69.7    0
+//
+// 每次迭代向新建的集合加入 n 个不同的键，报告每个键的耗时 (ns/key)、
+// 集合占用的堆内存 (MB 和 B/key) 以及被误判为重复的键的比例 (false-dup-%，只有 bloom 不为 0)
+func benchmarkDedup(b *testing.B, algorithm string) {
+	for _, size := range dedupBenchSizes {
+		b.Run(size.name, func(b *testing.B) {
+			if size.n > 10_000_000 && testing.Short() {
+				b.Skip("skipping 100M keys in short mode")
+			}
+			var heap int64
+			var falseDups int
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				runtime.GC()
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				b.StartTimer()
+
+				set, err := newKeySet(algorithm, size.n)
+				if err != nil {
+					b.Fatal(err)
+				}
+				falseDups = 0
+				for j := 0; j < size.n; j++ {
+					if !set.Add("session:" + strconv.Itoa(j)) {
+						falseDups++
+					}
+				}
+
+				// 回收不被集合引用的临时键名后再统计
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				heap = int64(after.HeapAlloc) - int64(before.HeapAlloc)
+				runtime.KeepAlive(set)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/float64(size.n), "ns/key")
+			b.ReportMetric(float64(heap)/(1<<20), "MB")
+			b.ReportMetric(float64(heap)/float64(size.n), "B/key")
+			b.ReportMetric(float64(falseDups)/float64(size.n)*100, "false-dup-%")
+		})
+	}
+}
+
+func BenchmarkDedup_Map(b *testing.B) {
+	benchmarkDedup(b, dedupAlgorithmMap)
+}
+
+func BenchmarkDedup_Bloom(b *testing.B) {
+	benchmarkDedup(b, dedupAlgorithmBloom)
+}
+
+func BenchmarkDedup_RobinHood(b *testing.B) {
+	benchmarkDedup(b, dedupAlgorithmRobinHood)
+}
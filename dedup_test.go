@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDedupCacheWindow(t *testing.T) {
+	c := newDedupCache(time.Minute, 100)
+	ev := ExpiredEvent{Channel: expiredChannel, Key: "session:1"}
+	start := time.Now()
+	ctx := context.Background()
+
+	if c.seenRecently(ctx, ev, start) {
+		t.Error("first event reported as a duplicate")
+	}
+	if !c.seenRecently(ctx, ev, start.Add(30*time.Second)) {
+		t.Error("event within the window not reported as a duplicate")
+	}
+	if c.seenRecently(ctx, ev, start.Add(2*time.Minute)) {
+		t.Error("event after the window reported as a duplicate")
+	}
+	if !c.seenRecently(ctx, ev, start.Add(2*time.Minute+time.Second)) {
+		t.Error("window was not restarted by the refreshed event")
+	}
+	if n := c.size.Load(); n != 1 {
+		t.Errorf("size = %d, want 1", n)
+	}
+}
+
+// 多个 worker 同时处理同一个键时只写入一次，包括新键和窗口外需要刷新的键
+func TestDedupCacheConcurrent(t *testing.T) {
+	const workers = 32
+	c := newDedupCache(time.Minute, 100)
+	ev := ExpiredEvent{Channel: expiredChannel, Key: "session:1"}
+	start := time.Now()
+
+	for round, now := range []time.Time{start, start.Add(2 * time.Minute)} {
+		var written atomic.Int32
+		var ready, done sync.WaitGroup
+		ready.Add(1)
+		for i := 0; i < workers; i++ {
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				ready.Wait()
+				if !c.seenRecently(context.Background(), ev, now) {
+					written.Add(1)
+				}
+			}()
+		}
+		ready.Done()
+		done.Wait()
+		if n := written.Load(); n != 1 {
+			t.Errorf("round %d: %d workers wrote the key, want 1", round, n)
+		}
+	}
+}
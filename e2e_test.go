@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// 子进程中运行 TestE2EWriteFailure 的写入失败场景
+const e2eHelperEnv = "REDIS_EXPIRE_KEYS_E2E_HELPER"
+
+// expiredChannel 是 db 0 的过期事件频道
+const expiredChannel = "__keyevent@0__:expired"
+
+// e2eRun 是订阅、写入过期键文件和惰性删除的完整流程，组装方式与 main 相同
+type e2eRun struct {
+	mr      *miniredis.Miniredis
+	rdb     *redis.Client
+	store   *FileKeyStore
+	cleaner *Cleaner
+}
+
+// 启动 miniredis，订阅过期事件，并由 eventHandler 写入 keyFile。
+// miniredis 不支持 CONFIG，跳过 configureKeyspaceNotifications 直接订阅
+func startE2E(t *testing.T, keyFile string) *e2eRun {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	pubsub := rdb.PSubscribe(ctx, expiredChannel)
+	t.Cleanup(func() { pubsub.Close() })
+	if _, err := pubsub.Receive(ctx); err != nil {
+		t.Fatalf("PSUBSCRIBE: %v", err)
+	}
+	collector := newPubsubCollector(rdb, []string{expiredChannel}, pubsub, 0, nil)
+
+	cfg := &Config{KeyFile: keyFile, Format: formatText}
+	store := NewFileKeyStore(cfg.KeyFile, cfg.Format)
+	events := make(chan ExpiredEvent, 100)
+	go func() {
+		collector.Collect(ctx, events)
+		close(events)
+	}()
+	handler := &eventHandler{cfg: cfg, rdb: rdb, store: store}
+	go handler.run(ctx, events)
+
+	strategy, err := newDeletionStrategy(strategyType)
+	if err != nil {
+		t.Fatalf("newDeletionStrategy: %v", err)
+	}
+	return &e2eRun{
+		mr:      mr,
+		rdb:     rdb,
+		store:   store,
+		cleaner: &Cleaner{rdb: rdb, store: store, cfg: cfg, strategy: strategy},
+	}
+}
+
+// fastForward 推进 miniredis 的时钟。miniredis 删除到期的键时不发布 keyspace 通知，
+// 所以先记下 d 之内到期的键，推进后对已经被删除的键发布过期事件，与 Redis 主动过期时相同
+func (e *e2eRun) fastForward(d time.Duration) {
+	var expiring []string
+	for _, key := range e.mr.Keys() {
+		if ttl := e.mr.TTL(key); ttl > 0 && ttl <= d {
+			expiring = append(expiring, key)
+		}
+	}
+	e.mr.FastForward(d)
+	for _, key := range expiring {
+		if !e.mr.Exists(key) {
+			e.mr.Publish(expiredChannel, key)
+		}
+	}
+}
+
+func (e *e2eRun) set(t *testing.T, key string, ttl time.Duration) {
+	t.Helper()
+	if err := e.rdb.Set(context.Background(), key, "v", ttl).Err(); err != nil {
+		t.Fatalf("SET %s: %v", key, err)
+	}
+}
+
+// 轮询过期键文件，直到包含 keys 中的所有键
+func waitForKeys(t *testing.T, path string, keys ...string) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		lines := strings.Fields(string(data))
+		if containsAll(lines, keys) {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s = %q after 5s, want %q", path, lines, keys)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func containsAll(lines, keys []string) bool {
+	seen := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		seen[line] = true
+	}
+	for _, key := range keys {
+		if !seen[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// 读取审计日志，返回键 -> 处理结果
+func readAudit(t *testing.T, path string) map[string]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer file.Close()
+
+	outcomes := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("audit record %q: %v", scanner.Text(), err)
+		}
+		outcomes[rec.Key] = rec.Outcome
+	}
+	return outcomes
+}
+
+func TestE2EExpiryToCleanup(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "expired_keys.txt")
+	e := startE2E(t, keyFile)
+	audit, err := OpenAuditLog(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	e.cleaner.audit = audit
+
+	e.set(t, "session:1", 10*time.Second)
+	e.set(t, "session:2", 10*time.Second)
+	e.set(t, "config:1", 0)
+
+	e.fastForward(11 * time.Second)
+	if lines := waitForKeys(t, keyFile, "session:1", "session:2"); len(lines) != 2 {
+		t.Errorf("key file = %q, want only the expired keys", lines)
+	}
+
+	// session:2 过期后被重新创建，清理时应当保留
+	e.set(t, "session:2", 0)
+
+	if err := e.cleaner.runCleanup(context.Background()); err != nil {
+		t.Fatalf("runCleanup: %v", err)
+	}
+	want := map[string]string{"session:1": "deleted", "session:2": "present"}
+	if got := readAudit(t, audit.path); len(got) != len(want) || got["session:1"] != want["session:1"] || got["session:2"] != want["session:2"] {
+		t.Errorf("cleanup outcomes = %v, want %v", got, want)
+	}
+	if !e.mr.Exists("session:2") || !e.mr.Exists("config:1") {
+		t.Error("cleanup removed a live key")
+	}
+
+	// 处理过的键从过期键文件中清空，清理成功后删除备份文件
+	if data, err := os.ReadFile(keyFile); err != nil || len(data) != 0 {
+		t.Errorf("key file after cleanup = %q (err %v), want empty", data, err)
+	}
+	if _, err := os.Stat(backupPath(keyFile, 1, compressionNone)); !os.IsNotExist(err) {
+		t.Errorf("backup file still exists after cleanup (err %v)", err)
+	}
+}
+
+// 后续的过期事件在一次清理之后继续写入文件
+func TestE2EEventsAfterCleanup(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "expired_keys.txt")
+	e := startE2E(t, keyFile)
+
+	e.set(t, "a", time.Second)
+	e.fastForward(2 * time.Second)
+	waitForKeys(t, keyFile, "a")
+	if err := e.cleaner.runCleanup(context.Background()); err != nil {
+		t.Fatalf("runCleanup: %v", err)
+	}
+
+	e.set(t, "b", time.Second)
+	e.fastForward(2 * time.Second)
+	if lines := waitForKeys(t, keyFile, "b"); len(lines) != 1 {
+		t.Errorf("key file = %q, want only b", lines)
+	}
+}
+
+// 写入过期键文件失败时 eventHandler 调用 log.Fatalf 退出进程 (由 systemd 等重启)，
+// 而不是丢弃事件继续运行。log.Fatalf 会结束测试进程，所以在子进程中运行
+func TestE2EWriteFailure(t *testing.T) {
+	if os.Getenv(e2eHelperEnv) == "1" {
+		// 过期键文件的父路径是普通文件，每次写入都返回 ENOTDIR (以 root 运行时只读目录无法阻止写入)
+		parent := filepath.Join(t.TempDir(), "not-a-dir")
+		if err := os.WriteFile(parent, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		e := startE2E(t, filepath.Join(parent, "expired_keys.txt"))
+		e.set(t, "session:1", time.Second)
+		e.fastForward(2 * time.Second)
+		time.Sleep(5 * time.Second)
+		t.Fatal("process did not exit after the write failure")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestE2EWriteFailure$", "-test.count=1")
+	cmd.Env = append(os.Environ(), e2eHelperEnv+"=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.Success() {
+		t.Fatalf("helper process error = %v, want a non-zero exit\n%s", err, stderr.String())
+	}
+	out := stderr.String()
+	if !strings.Contains(out, "Receive Key expired: session:1") {
+		t.Errorf("helper did not receive the expiry event:\n%s", out)
+	}
+	if !strings.Contains(out, "Failed to write expired key to file:") || !strings.Contains(out, "not a directory") {
+		t.Errorf("helper stderr does not contain the write failure:\n%s", out)
+	}
+}
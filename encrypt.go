@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// 开启 --key-file-encrypt 时过期键文件 (包括备份文件) 的加密方式，为 nil 时不加密。
+// 文件由若干块组成，每块为 [4 字节小端长度][12 字节 nonce][密文][16 字节认证标签]，
+// 每次追加写入一块，读取时逐块解密，不需要把整个文件读入内存。
+// --key-rotation-signal 轮换密钥时整体替换
+var keyFileCipher atomic.Pointer[recordCipher]
+
+// 轮换密钥时重新加密备份文件和失败键文件，清理会轮转、写入这些文件，
+// 所以清理期间持有读锁，轮换等正在进行的清理结束后才开始
+var keyRotationMu sync.RWMutex
+
+const (
+	encryptSaltLen    = 16
+	encryptIterations = 100000
+	encryptChunkSize  = 64 << 10 // 批量写入 (备份、重写文件) 时每块明文的大小
+	maxEncryptedChunk = encryptChunkSize + maxBinaryRecordLen
+)
+
+type recordCipher struct {
+	aead cipher.AEAD
+
+	// 之前各代的密钥，从新到旧，只用于解密。轮换会重新加密所有文件，
+	// 保留旧密钥是为了读取轮换前打开、轮换后才写入的内容
+	previous []cipher.AEAD
+}
+
+// 读取 --encryption-key 或 --encryption-key-env 指定的环境变量中的 base64 密钥材料，
+// 与 saltPath 中保存的盐 (不存在时随机生成) 用 PBKDF2 派生 AES-256 密钥
+func newRecordCipher(cfg *Config, saltPath string) (*recordCipher, error) {
+	encoded := cfg.EncryptionKey
+	if cfg.EncryptionKeyEnv != "" {
+		encoded = os.Getenv(cfg.EncryptionKeyEnv)
+		if encoded == "" {
+			return nil, fmt.Errorf("environment variable %s is empty", cfg.EncryptionKeyEnv)
+		}
+	}
+	if encoded == "" {
+		return nil, errors.New("--key-file-encrypt requires --encryption-key or --encryption-key-env")
+	}
+	c, err := deriveCipher(encoded, saltPath)
+	if err != nil {
+		return nil, err
+	}
+	// 新密钥只在环境变量中，不会写入磁盘。轮换后尚未更新 --encryption-key 就重启时，
+	// 用 --new-encryption-key-env 中的密钥解密轮换后写入的文件
+	if newEncoded := os.Getenv(cfg.NewEncryptionKeyEnv); newEncoded != "" && newEncoded != encoded {
+		next, err := deriveCipher(newEncoded, saltPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %s: %v", cfg.NewEncryptionKeyEnv, err)
+		}
+		c.previous = []cipher.AEAD{next.aead}
+	}
+	return c, nil
+}
+
+// 从 base64 密钥材料和盐派生 AES-256-GCM
+func deriveCipher(encoded, saltPath string) (*recordCipher, error) {
+	material, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 encryption key: %v", err)
+	}
+
+	salt, err := loadSalt(saltPath)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2.Key(material, salt, encryptIterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &recordCipher{aead: aead}, nil
+}
+
+// 读取盐文件，不存在时生成新的盐。盐文件丢失后已有的加密文件无法再解密
+func loadSalt(path string) ([]byte, error) {
+	salt, err := os.ReadFile(path)
+	if err == nil {
+		if len(salt) != encryptSaltLen {
+			return nil, fmt.Errorf("invalid salt file %s: expected %d bytes, got %d", path, encryptSaltLen, len(salt))
+		}
+		return salt, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	salt = make([]byte, encryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, salt, 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated new key file salt %s, keep it together with the key file", path)
+	return salt, nil
+}
+
+// 将已经加上分隔的记录加密为一块，未开启 --key-file-encrypt 时原样返回
+func sealFrames(frames []byte) []byte {
+	return keyFileCipher.Load().seal(frames)
+}
+
+func (c *recordCipher) seal(frames []byte) []byte {
+	if c == nil || len(frames) == 0 {
+		return frames
+	}
+	aead := c.aead
+	buf := make([]byte, 4+aead.NonceSize(), 4+aead.NonceSize()+len(frames)+aead.Overhead())
+	if _, err := rand.Read(buf[4:]); err != nil {
+		// crypto/rand 只在系统随机源不可用时失败，此时无法安全地加密
+		log.Fatalf("Failed to generate nonce: %v", err)
+	}
+	buf = aead.Seal(buf, buf[4:], frames, nil)
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)-4))
+	return buf
+}
+
+// sealWriter 将写入的明文按 encryptChunkSize 分块加密后写入 w，Close 时写入剩余部分，不关闭 w。
+// 写入的内容不必按记录对齐，例如压缩后的备份文件。cipher 为 nil 时直接写入 w
+type sealWriter struct {
+	w      io.Writer
+	cipher *recordCipher
+	buf    []byte
+}
+
+func newSealWriter(w io.Writer, c *recordCipher) *sealWriter {
+	return &sealWriter{w: w, cipher: c}
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	if s.cipher == nil {
+		return s.w.Write(p)
+	}
+	n := len(p)
+	for len(p) > 0 {
+		room := encryptChunkSize - len(s.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		s.buf = append(s.buf, p[:room]...)
+		p = p[room:]
+		if len(s.buf) == encryptChunkSize {
+			if err := s.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *sealWriter) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	_, err := s.w.Write(s.cipher.seal(s.buf))
+	s.buf = s.buf[:0]
+	return err
+}
+
+func (s *sealWriter) Close() error {
+	if s.cipher == nil {
+		return nil
+	}
+	return s.flush()
+}
+
+// 解密 sealFrames 写入的全部内容，未开启 --key-file-encrypt 时原样返回
+func unsealFrames(data []byte) ([]byte, error) {
+	return keyFileCipher.Load().unseal(data)
+}
+
+func (c *recordCipher) unseal(data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	return io.ReadAll(c.reader(bytes.NewReader(data)))
+}
+
+// 返回逐块解密 r 的 Reader，未开启 --key-file-encrypt 时返回 r
+func decryptReader(r io.Reader) io.Reader {
+	return keyFileCipher.Load().reader(r)
+}
+
+func (c *recordCipher) reader(r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+	return &chunkReader{r: r, aead: c.aead, previous: c.previous}
+}
+
+type chunkReader struct {
+	r        io.Reader
+	aead     cipher.AEAD
+	previous []cipher.AEAD
+	plain    []byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+// 读取并解密下一块。与 binary-log 相同，文件末尾不完整的块 (例如写入进程异常退出) 会被跳过
+func (c *chunkReader) next() error {
+	var header [4]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("WARN: Skipping partial encrypted chunk at end of file")
+			return io.EOF
+		}
+		return err
+	}
+	n := binary.LittleEndian.Uint32(header[:])
+	if n > maxEncryptedChunk || int(n) < c.aead.NonceSize()+c.aead.Overhead() {
+		return fmt.Errorf("corrupt encrypted chunk length %d", n)
+	}
+	chunk := make([]byte, n)
+	if _, err := io.ReadFull(c.r, chunk); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			log.Printf("WARN: Skipping partial encrypted chunk at end of file (%d bytes)", n)
+			return io.EOF
+		}
+		return err
+	}
+	nonce, ciphertext := chunk[:c.aead.NonceSize()], chunk[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	for _, previous := range c.previous {
+		if err == nil {
+			break
+		}
+		plain, err = previous.Open(nil, nonce, ciphertext, nil)
+	}
+	if err != nil {
+		return errors.New("failed to decrypt key file chunk (wrong --encryption-key, or the file was not written with --key-file-encrypt)")
+	}
+	c.plain = plain
+	return nil
+}
+
+// 轮换 --key-file-encrypt 的密钥 (--key-rotation-signal)：从 --new-encryption-key-env 读取新密钥，
+// 把每个过期键文件、它们的备份文件和 errorsFiles 用新密钥重新加密。
+// 先把所有文件写入临时文件，全部成功后才依次 rename 覆盖，任何一个失败时都保留原文件和旧密钥。
+// 轮换期间持有所有文件的锁并等待正在进行的清理结束，完成后新的写入才使用新密钥。
+// 新密钥不会保存，重启前需要把 --encryption-key (或 --encryption-key-env 指向的变量) 更新为新密钥
+func rotateEncryptionKey(stores []*FileKeyStore, errorsFiles []string, newKeyEnv, saltPath string) error {
+	encoded := os.Getenv(newKeyEnv)
+	if encoded == "" {
+		return fmt.Errorf("environment variable %s is empty", newKeyEnv)
+	}
+	next, err := deriveCipher(encoded, saltPath)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	log.Printf("Key file encryption key rotation started at %s", start.Format(time.RFC3339))
+	keyRotationMu.Lock()
+	defer keyRotationMu.Unlock()
+	for _, store := range stores {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+	}
+
+	cur := keyFileCipher.Load()
+	var pending []*pendingFile
+	abort := func() {
+		for _, p := range pending {
+			p.abort()
+		}
+	}
+	sealed := errorsFiles
+	for _, store := range stores {
+		p, _, err := store.prepareRewrite(next, func(int64, KeyRecord) bool { return true })
+		if err != nil {
+			abort()
+			return fmt.Errorf("failed to re-encrypt %s, keeping the old key: %v", store.path, err)
+		}
+		if p != nil {
+			pending = append(pending, p)
+		}
+		backups, err := filepath.Glob(store.path + ".bak.*")
+		if err != nil {
+			abort()
+			return err
+		}
+		for _, backup := range backups {
+			// 跳过中途崩溃时留下的临时文件
+			if !strings.Contains(strings.TrimPrefix(backup, store.path), ".tmp") {
+				sealed = append(sealed, backup)
+			}
+		}
+	}
+	for _, path := range sealed {
+		p, err := prepareReseal(path, cur, next)
+		if err != nil {
+			abort()
+			return fmt.Errorf("failed to re-encrypt %s, keeping the old key: %v", path, err)
+		}
+		if p != nil {
+			pending = append(pending, p)
+		}
+	}
+
+	// 所有临时文件都已写好。rename 失败的文件仍然是旧密钥加密的，
+	// 新密钥之后仍保留旧密钥用于解密，本进程可以继续读取
+	next.previous = append([]cipher.AEAD{cur.aead}, cur.previous...)
+	var failed []string
+	for _, p := range pending {
+		if err := p.commit(); err != nil {
+			log.Printf("Failed to replace %s with the re-encrypted file: %v", p.path, err)
+			failed = append(failed, p.path)
+		}
+	}
+	keyFileCipher.Store(next)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d files are still encrypted with the old key and cannot be read after a restart with the new key: %s",
+			len(failed), strings.Join(failed, ", "))
+	}
+	log.Printf("Key file encryption key rotation finished at %s (%d files, took %v)",
+		time.Now().Format(time.RFC3339), len(pending), time.Since(start))
+	log.Printf("WARN: The new key is not saved anywhere: update --encryption-key or --encryption-key-env to the key in %s before the next restart", newKeyEnv)
+	return nil
+}
+
+// 用 cur 解密 path 的内容，再用 next 重新加密写入临时文件，由调用方 commit 或 abort。
+// 文件不存在时返回 nil。只替换加密层，压缩的备份文件仍然是压缩的
+func prepareReseal(path string, cur, next *recordCipher) (*pendingFile, error) {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	p, err := newPendingFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	sealer := newSealWriter(p.tmp, next)
+	if _, err := io.Copy(sealer, cur.reader(src)); err != nil {
+		p.abort()
+		return nil, err
+	}
+	if err := sealer.Close(); err != nil {
+		p.abort()
+		return nil, err
+	}
+	if err := p.tmp.Sync(); err != nil {
+		p.abort()
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const rotationKeyEnv = "REDIS_EXPIRE_KEYS_TEST_NEW_KEY"
+
+func testKey(seed string) string {
+	return base64.StdEncoding.EncodeToString([]byte(seed + "-0123456789abcdef0123456789"))
+}
+
+// 用 encoded 开启 --key-file-encrypt，测试结束后关闭
+func useTestCipher(t *testing.T, encoded, saltPath string) {
+	t.Helper()
+	c, err := deriveCipher(encoded, saltPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFileCipher.Store(c)
+	t.Cleanup(func() { keyFileCipher.Store(nil) })
+}
+
+// 只用 encoded 解密 path (相当于用新密钥重启)，compressed 时再解压
+func readSealed(t *testing.T, path, encoded, saltPath string, compressed bool) string {
+	t.Helper()
+	c, err := deriveCipher(encoded, saltPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := c.unseal(data)
+	if err != nil {
+		t.Fatalf("decrypt %s with the current key: %v", filepath.Base(path), err)
+	}
+	if !compressed {
+		return string(plain)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// 写入用当前密钥加密、gzip 压缩的备份文件
+func writeSealedBackup(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sealer := newSealWriter(file, keyFileCipher.Load())
+	zw := gzip.NewWriter(sealer)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sealer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 多次轮换之后，过期键文件、每一代的备份文件和失败键文件都可以只用最新的密钥读取
+func TestRotateEncryptionKeyRepeated(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "expired_keys.txt")
+	saltPath := keyFile + ".salt"
+	errorsFile := filepath.Join(dir, "errors.txt")
+	useTestCipher(t, testKey("gen0"), saltPath)
+
+	store := NewFileKeyStore(keyFile, formatText)
+	if err := store.Append(KeyRecord{Key: "session:1"}); err != nil {
+		t.Fatal(err)
+	}
+	writeSealedBackup(t, backupPath(keyFile, 2, compressionGzip), "old:1\n")
+	if err := appendFramesToFile(errorsFile, sealFrames(frameRecord("failed:1", formatText))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, gen := range []string{"gen1", "gen2"} {
+		t.Setenv(rotationKeyEnv, testKey(gen))
+		if err := rotateEncryptionKey([]*FileKeyStore{store}, []string{errorsFile}, rotationKeyEnv, saltPath); err != nil {
+			t.Fatalf("rotate to %s: %v", gen, err)
+		}
+		if gen == "gen1" {
+			// 第一次轮换之后写入的备份
+			writeSealedBackup(t, backupPath(keyFile, 1, compressionGzip), "mid:1\n")
+		}
+	}
+
+	if err := store.Append(KeyRecord{Key: "session:2"}); err != nil {
+		t.Fatal(err)
+	}
+	final := testKey("gen2")
+	if got := readSealed(t, keyFile, final, saltPath, false); got != "session:1\nsession:2\n" {
+		t.Errorf("key file = %q", got)
+	}
+	if got := readSealed(t, backupPath(keyFile, 1, compressionGzip), final, saltPath, true); got != "mid:1\n" {
+		t.Errorf("backup 1 = %q", got)
+	}
+	if got := readSealed(t, backupPath(keyFile, 2, compressionGzip), final, saltPath, true); got != "old:1\n" {
+		t.Errorf("backup 2 = %q", got)
+	}
+	if got := readSealed(t, errorsFile, final, saltPath, false); got != "failed:1\n" {
+		t.Errorf("errors file = %q", got)
+	}
+	if n := len(keyFileCipher.Load().previous); n != 2 {
+		t.Errorf("cipher keeps %d previous keys, want 2", n)
+	}
+}
+
+// 任何一个文件无法重新加密时，所有文件保持原样，继续使用旧密钥
+func TestRotateEncryptionKeyAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "expired_keys.txt")
+	saltPath := keyFile + ".salt"
+	useTestCipher(t, testKey("gen0"), saltPath)
+
+	store := NewFileKeyStore(keyFile, formatText)
+	if err := store.Append(KeyRecord{Key: "session:1"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 用其他密钥加密的备份无法解密
+	corrupt := backupPath(keyFile, 1, compressionNone)
+	other, err := deriveCipher(testKey("other"), saltPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(corrupt, other.seal([]byte("x\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := keyFileCipher.Load()
+
+	t.Setenv(rotationKeyEnv, testKey("gen1"))
+	if err := rotateEncryptionKey([]*FileKeyStore{store}, nil, rotationKeyEnv, saltPath); err == nil {
+		t.Fatal("rotateEncryptionKey succeeded with an undecryptable backup")
+	}
+	if keyFileCipher.Load() != old {
+		t.Error("cipher was replaced after a failed rotation")
+	}
+	after, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("key file was rewritten by a failed rotation")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp*")); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %q", matches)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrorCategory 是 Redis 调用错误的分类，决定出错后重试、跳过还是退出
+type ErrorCategory int
+
+const (
+	ErrNone              ErrorCategory = iota // 没有错误
+	ErrConnectionRefused                      // Redis 不可达，重试
+	ErrAuthFailure                            // 密码错误，退出
+	ErrKeyNotFound                            // 键不存在，跳过
+	ErrPermission                             // ACL 拒绝，记录后跳过
+	ErrTimeout                                // 超时，退避后重试
+	ErrUnknown                                // 其他错误，记录后跳过
+)
+
+// 临时错误的重试次数和初始退避间隔
+const (
+	errorRetries      = 3
+	errorRetryBackoff = 100 * time.Millisecond
+)
+
+func (c ErrorCategory) String() string {
+	switch c {
+	case ErrNone:
+		return "none"
+	case ErrConnectionRefused:
+		return "connection_refused"
+	case ErrAuthFailure:
+		return "auth_failure"
+	case ErrKeyNotFound:
+		return "key_not_found"
+	case ErrPermission:
+		return "permission"
+	case ErrTimeout:
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// keyError 是未开启 --ignore-errors 时处理某个键失败 (连接、超时或认证错误) 的错误。
+// 本次清理在该键处中止，由 --exit-on-cleanup-error 决定退出还是等待下一次清理
+type keyError struct {
+	key      string
+	category ErrorCategory
+	err      error
+}
+
+func (e *keyError) Error() string {
+	return fmt.Sprintf("failed to process key %s (%v error): %v", e.key, e.category, e.err)
+}
+
+func (e *keyError) Unwrap() error {
+	return e.err
+}
+
+// retryable 判断该类错误是否为临时错误，值得重试
+func (c ErrorCategory) retryable() bool {
+	return c == ErrConnectionRefused || c == ErrTimeout
+}
+
+// skippable 判断出现该类错误时是否可以跳过当前键继续处理
+func (c ErrorCategory) skippable() bool {
+	return c == ErrKeyNotFound || c == ErrPermission || c == ErrUnknown
+}
+
+// classifyError 根据错误类型和 Redis 返回的错误前缀对错误分类
+func classifyError(err error) ErrorCategory {
+	if err == nil {
+		return ErrNone
+	}
+	if err == redis.Nil {
+		return ErrKeyNotFound
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, errSubscriptionClosed) {
+		return ErrConnectionRefused
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "NOAUTH"), strings.HasPrefix(msg, "WRONGPASS"),
+		strings.Contains(msg, "invalid password"), strings.Contains(msg, "invalid username-password"):
+		return ErrAuthFailure
+	case strings.HasPrefix(msg, "NOPERM"):
+		return ErrPermission
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "connection reset"),
+		strings.HasPrefix(msg, "LOADING"), msg == "EOF", msg == "redis: client is closed":
+		return ErrConnectionRefused
+	case strings.Contains(msg, "i/o timeout"):
+		return ErrTimeout
+	}
+	return ErrUnknown
+}
+
+// 执行 fn，遇到临时错误时按指数退避重试，最多 errorRetries 次
+func retryOnTransient(ctx context.Context, fn func() error) error {
+	backoff := errorRetryBackoff
+	err := fn()
+	for attempt := 1; attempt <= errorRetries && classifyError(err).retryable(); attempt++ {
+		debugf("Retrying after %v error (attempt %d/%d): %v", classifyError(err), attempt, errorRetries, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCategory
+	}{
+		{"nil", nil, ErrNone},
+		{"redis nil", redis.Nil, ErrKeyNotFound},
+		{"deadline", context.DeadlineExceeded, ErrTimeout},
+		{"wrapped deadline", fmt.Errorf("type session:1: %w", context.DeadlineExceeded), ErrTimeout},
+		{"net timeout", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, ErrTimeout},
+		{"i/o timeout message", errors.New("read tcp 127.0.0.1:50000->127.0.0.1:6379: i/o timeout"), ErrTimeout},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ErrConnectionRefused},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, ErrConnectionRefused},
+		{"refused message", errors.New("dial tcp 10.0.0.1:6379: connect: connection refused"), ErrConnectionRefused},
+		{"loading", errors.New("LOADING Redis is loading the dataset in memory"), ErrConnectionRefused},
+		{"eof", io.EOF, ErrConnectionRefused},
+		{"client closed", redis.ErrClosed, ErrConnectionRefused},
+		{"subscription closed", errSubscriptionClosed, ErrConnectionRefused},
+		{"noauth", errors.New("NOAUTH Authentication required."), ErrAuthFailure},
+		{"wrongpass", errors.New("WRONGPASS invalid username-password pair or user is disabled."), ErrAuthFailure},
+		{"invalid password", errors.New("ERR invalid password"), ErrAuthFailure},
+		{"noperm", errors.New("NOPERM this user has no permissions to run the 'type' command"), ErrPermission},
+		{"wrongtype", errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"), ErrUnknown},
+		{"other", errors.New("ERR unknown command 'EXPIRETIME'"), ErrUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorCategoryActions(t *testing.T) {
+	tests := []struct {
+		category  ErrorCategory
+		name      string
+		retryable bool
+		skippable bool
+	}{
+		{ErrNone, "none", false, false},
+		{ErrConnectionRefused, "connection_refused", true, false},
+		{ErrAuthFailure, "auth_failure", false, false},
+		{ErrKeyNotFound, "key_not_found", false, true},
+		{ErrPermission, "permission", false, true},
+		{ErrTimeout, "timeout", true, false},
+		{ErrUnknown, "unknown", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.category.String(); got != tt.name {
+				t.Errorf("String() = %q, want %q", got, tt.name)
+			}
+			if got := tt.category.retryable(); got != tt.retryable {
+				t.Errorf("retryable() = %v, want %v", got, tt.retryable)
+			}
+			if got := tt.category.skippable(); got != tt.skippable {
+				t.Errorf("skippable() = %v, want %v", got, tt.skippable)
+			}
+		})
+	}
+}
+
+func TestRetryOnTransient(t *testing.T) {
+	refused := errors.New("connection refused")
+	noperm := errors.New("NOPERM no permissions")
+	tests := []struct {
+		name      string
+		errs      []error // 每次调用依次返回的错误，用完后返回 nil
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "success", wantCalls: 1},
+		{name: "permanent error is not retried", errs: []error{noperm}, wantErr: noperm, wantCalls: 1},
+		{name: "transient error then success", errs: []error{refused, refused}, wantCalls: 3},
+		{name: "transient error exhausts retries", errs: []error{refused, refused, refused, refused, refused}, wantErr: refused, wantCalls: errorRetries + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryOnTransient(context.Background(), func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Errorf("retryOnTransient() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 在键预计过期之前多久读取它的值
+const preExpiryExportLead = 100 * time.Millisecond
+
+// keyExporter 在键过期之前导出它的值 (--key-value-export-before-expiry)。
+// 过期事件发出时键已被删除，因此改为监听 set 事件：TTL 低于阈值的键，
+// 在预计过期前 100ms 执行 DUMP 并写入 <export-dir>/<键名>.<毫秒时间戳>.dump。
+// 这是尽力而为的：工具繁忙或键被修改了 TTL 时可能错过。
+// 只导出 --db 中的键，按数据库分文件时其他数据库的 set 事件被忽略
+type keyExporter struct {
+	rdb       *redis.Client
+	db        int
+	threshold time.Duration
+	dir       string
+}
+
+func newKeyExporter(rdb *redis.Client, db int, threshold time.Duration, dir string) (*keyExporter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &keyExporter{rdb: rdb, db: db, threshold: threshold, dir: dir}, nil
+}
+
+// Schedule 处理一个 set 事件，e 为 nil 时不做任何事
+func (e *keyExporter) Schedule(ctx context.Context, key string, db int) {
+	if e == nil || db != e.db {
+		return
+	}
+	ttl, err := e.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		debugf("Failed to get TTL of %s for export: %v", key, err)
+		return
+	}
+	// 没有过期时间 (-1)、已不存在 (-2) 或 TTL 不低于阈值的键不导出
+	if ttl <= 0 || ttl >= e.threshold {
+		return
+	}
+
+	delay := ttl - preExpiryExportLead
+	if delay < 0 {
+		delay = 0
+	}
+	time.AfterFunc(delay, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := e.export(ctx, key); err != nil {
+			log.Printf("Failed to export key %s before expiry: %v", key, err)
+		}
+	})
+}
+
+func (e *keyExporter) export(ctx context.Context, key string) error {
+	data, err := e.rdb.Dump(ctx, key).Result()
+	if err == redis.Nil {
+		debugf("Key %s expired or was deleted before export", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s.%d.dump", url.PathEscape(key), time.Now().UnixMilli())
+	path := filepath.Join(e.dir, name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		return err
+	}
+	debugf("Exported key %s to %s before expiry", key, path)
+	return nil
+}
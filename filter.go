@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// keyFilter 根据黑名单和白名单过滤过期键。名单文件每行一个 glob 模式，
+// 空行和 # 开头的行会被忽略。设置白名单时只处理匹配白名单的键，
+// 匹配黑名单的键始终不处理
+type keyFilter struct {
+	blacklistPath string
+	whitelistPath string
+
+	mu        sync.RWMutex
+	blacklist []string
+	whitelist []string
+}
+
+// newKeyFilter 读取黑名单和白名单文件，路径为空表示不使用对应的名单
+func newKeyFilter(blacklistPath, whitelistPath string) (*keyFilter, error) {
+	f := &keyFilter{blacklistPath: blacklistPath, whitelistPath: whitelistPath}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload 重新读取名单文件，读取失败时保留原来的名单
+func (f *keyFilter) Reload() error {
+	blacklist, err := loadPatterns(f.blacklistPath)
+	if err != nil {
+		return err
+	}
+	whitelist, err := loadPatterns(f.whitelistPath)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blacklist = blacklist
+	f.whitelist = whitelist
+	return nil
+}
+
+// Allow 判断是否处理 key，f 为 nil 时处理所有键
+func (f *keyFilter) Allow(key string) bool {
+	if f == nil {
+		return true
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, pattern := range f.blacklist {
+		if matchPattern(pattern, key) {
+			return false
+		}
+	}
+	if f.whitelistPath == "" {
+		return true
+	}
+	for _, pattern := range f.whitelist {
+		if matchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch 监听名单文件的变化并自动重新读取，直到 ctx 被取消
+func (f *keyFilter) Watch(ctx context.Context) error {
+	return watchFiles(ctx, []string{f.blacklistPath, f.whitelistPath}, func(name string) {
+		if err := f.Reload(); err != nil {
+			log.Printf("Failed to reload filter files after %s changed: %v", name, err)
+		} else {
+			log.Printf("Reloaded filter files: %s changed", name)
+		}
+	})
+}
+
+// watchFiles 在 paths 中的文件被写入、创建或替换时调用 onChange，直到 ctx 被取消，空路径被忽略。
+// 监听的是文件所在的目录，以便兼容编辑器通过重命名替换文件的写法
+func watchFiles(ctx context.Context, paths []string, onChange func(name string)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	files := make(map[string]bool)
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		p = filepath.Clean(p)
+		files[p] = true
+		if err := watcher.Add(filepath.Dir(p)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !files[filepath.Clean(ev.Name)] || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			onChange(ev.Name)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("File watcher error: %v", err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// 读取名单文件中的 glob 模式，路径为空时返回空名单
+func loadPatterns(filePath string) ([]string, error) {
+	if filePath == "" {
+		return nil, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 提前检查模式语法，避免匹配时才发现错误
+		if err := validatePattern(line); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// 文件打开时可以被 rename 覆盖
+const renameWhileOpen = true
+
+// 对文件加 flock 锁，exclusive 为 false 时加共享锁，--no-flock 时不加锁
+func lockFile(file *os.File, exclusive bool) error {
+	if !fileLocking {
+		return nil
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(int(file.Fd()), how)
+}
+
+// 尝试对文件加排他锁，不等待，锁已被其他进程持有时返回 false。
+// 用于清理锁，不受 --no-flock 影响
+func tryLockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 释放 tryLockFile 加的锁
+func unlockFileAlways(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+// 释放 lockFile 加的锁，关闭文件时也会自动释放
+func unlockFile(file *os.File) error {
+	if !fileLocking {
+		return nil
+	}
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows 下打开的文件不能被 rename 覆盖，需要先关闭
+const renameWhileOpen = false
+
+// Windows 下不支持 flock，加锁为空操作
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
+
+// Windows 下清理锁总是成功，多个实例需要使用 --lock-backend=redis
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFileAlways(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import "fmt"
+
+// 模式为空时匹配所有键
+func matchPattern(pattern, key string) bool {
+	if pattern == "" {
+		return true
+	}
+	return globMatch(pattern, key)
+}
+
+// globMatch 按 Redis KEYS / PSUBSCRIBE 的 glob 规则匹配 key：* 匹配任意字节序列，
+// ? 匹配任意一个字节，[abc]、[^abc]、[a-z] 匹配字符集合，\ 转义下一个字符。
+// 与 path.Match 不同，/ 没有特殊含义，session:* 也匹配 session:a/b
+func globMatch(pattern, key string) bool {
+	px, kx := 0, 0
+	// 最近一个 * 的位置和它开始匹配的位置，后面匹配失败时让 * 多匹配一个字节再试
+	starPx, starKx := -1, 0
+	for kx < len(key) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starKx = px, kx
+				px++
+				continue
+			case '?':
+				px++
+				kx++
+				continue
+			case '[':
+				if ok, next := matchClass(pattern, px, key[kx]); ok {
+					px = next
+					kx++
+					continue
+				}
+			case '\\':
+				if px+1 < len(pattern) && pattern[px+1] == key[kx] {
+					px += 2
+					kx++
+					continue
+				}
+			default:
+				if c == key[kx] {
+					px++
+					kx++
+					continue
+				}
+			}
+		}
+		if starPx < 0 {
+			return false
+		}
+		starKx++
+		px, kx = starPx+1, starKx
+	}
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+	return px == len(pattern)
+}
+
+// 匹配 pattern[px] 开始的字符集合 [...]，返回 c 是否在集合中以及 ] 之后的位置
+func matchClass(pattern string, px int, c byte) (bool, int) {
+	i := px + 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for i < len(pattern) && pattern[i] != ']' {
+		lo := pattern[i]
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			i += 2
+			hi = pattern[i]
+			if hi == '\\' && i+1 < len(pattern) {
+				i++
+				hi = pattern[i]
+			}
+		}
+		// 与 Redis 相同，[z-a] 等同于 [a-z]
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+	if i >= len(pattern) {
+		return false, i
+	}
+	return matched != negate, i + 1
+}
+
+// validatePattern 检查 glob 模式的语法：[ 必须有对应的 ]，\ 不能是最后一个字符。
+// Redis 对这类模式不报错，但结果往往不是想要的，所以在读取配置时拒绝
+func validatePattern(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i+1 == len(pattern) {
+				return fmt.Errorf("invalid pattern %q: trailing backslash", pattern)
+			}
+			i++
+		case '[':
+			j := i + 1
+			if j < len(pattern) && pattern[j] == '^' {
+				j++
+			}
+			for j < len(pattern) && pattern[j] != ']' {
+				if pattern[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(pattern) {
+				return fmt.Errorf("invalid pattern %q: missing ]", pattern)
+			}
+			i = j
+		}
+	}
+	return nil
+}
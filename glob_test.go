@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"", "anything", true},
+		{"session:*", "session:1", true},
+		{"session:*", "session:a/b", true},
+		{"session:*", "session:", true},
+		{"session:*", "sessions:1", false},
+		{"*:tmp", "cache/user:1/tmp:tmp", true},
+		{"a/*/c", "a/b/x/c", true},
+		{"user:?", "user:/", true},
+		{"user:?", "user:10", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"key:[0-9]", "key:7", true},
+		{"key:[9-0]", "key:7", true},
+		{"key:[0-9]", "key:a", false},
+		{"path:[/]x", "path:/x", true},
+		{`lit\*`, "lit*", true},
+		{`lit\*`, "litx", false},
+		{`[\]]`, "]", true},
+		{"*a*b*c", "xaxbxbxc", true},
+		{"*a*b*c", "xaxbxbx", false},
+		{"**", "", true},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, pattern := range []string{"session:*", "a/b/*", "key:[0-9]", `lit\*`, `[\]]`, "[^a]"} {
+		if err := validatePattern(pattern); err != nil {
+			t.Errorf("validatePattern(%q) = %v, want nil", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"session:[", "key:[0-9", `trailing\`, `[\]`} {
+		if err := validatePattern(pattern); err == nil {
+			t.Errorf("validatePattern(%q) succeeded, want error", pattern)
+		}
+	}
+}
+
+// 名单文件、--config 和 --type-handler-config 中的无效模式在读取时报错
+func TestInvalidPatternsRejectedAtLoad(t *testing.T) {
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "blacklist.txt")
+	if err := os.WriteFile(listFile, []byte("session:*\nkey:[0-9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPatterns(listFile); err == nil {
+		t.Error("loadPatterns accepted an unterminated [")
+	}
+
+	if _, err := parseIntervalRules(map[string]string{"key:[0-9": "10ms"}, time.Second); err == nil {
+		t.Error("parseIntervalRules accepted an unterminated [")
+	}
+
+	handlerFile := filepath.Join(dir, "handlers.yaml")
+	if err := os.WriteFile(handlerFile, []byte("\"sessions:[\":\n  type: hash\n  del_fields: [\"data\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTypeHandlers(handlerFile); err == nil {
+		t.Error("loadTypeHandlers accepted an unterminated [")
+	}
+}
+
+// 键名中包含 / 时名单和删除间隔仍然按 Redis 的规则匹配
+func TestPatternsMatchKeysWithSlash(t *testing.T) {
+	f := &keyFilter{blacklist: []string{"lock:*"}}
+	if f.Allow("lock:a/b") {
+		t.Error("blacklist lock:* did not match lock:a/b")
+	}
+
+	rules, err := parseIntervalRules(map[string]string{"session:*": "10ms"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resolveInterval("session:a/b", rules); got != 10*time.Millisecond {
+		t.Errorf("resolveInterval(session:a/b) = %v, want 10ms", got)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"net"
+
+	"google.golang.org/grpc"
+
+	"github.com/RESIDUALWASTE/RedisExpireKeysDelete/keyeventpb"
+)
+
+// keyEventServer 通过 gRPC 流实时推送过期事件
+type keyEventServer struct {
+	keyeventpb.UnimplementedKeyEventServiceServer
+	broker *eventBroker
+}
+
+func (s *keyEventServer) StreamExpiredKeys(_ *keyeventpb.StreamRequest, stream keyeventpb.KeyEventService_StreamExpiredKeysServer) error {
+	ch := s.broker.Subscribe(1000)
+	defer s.broker.Unsubscribe(ch)
+
+	for {
+		select {
+		case ev := <-ch:
+			rec := ev.Record
+			err := stream.Send(&keyeventpb.KeyEvent{Key: rec.Key, Db: int32(rec.DB), Ts: rec.TS})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// 在 addr 上启动 gRPC 服务
+func serveGRPC(addr string, broker *eventBroker) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+
+	server := grpc.NewServer()
+	keyeventpb.RegisterKeyEventServiceServer(server, &keyEventServer{broker: broker})
+
+	go func() {
+		log.Printf("Serving gRPC on %s", addr)
+		if err := server.Serve(lis); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+// eventHandler 将收集到的过期事件写入过期键文件
+type eventHandler struct {
+	cfg      *Config
+	rdb      *redis.Client
+	store    *FileKeyStore
+	dbStore  *DBKeyStore // 按数据库分文件时使用
+	dedup    deduper     // 开启 --dedup-on-write 时使用
+	metrics  *Metrics
+	analyzer *KeyAnalyzer  // 开启 --analyze 时使用
+	prefixes *prefixStats  // 开启 --key-prefix-stats 时使用
+	broker   *eventBroker  // 向实时订阅者广播事件
+	filter   *keyFilter    // 黑名单 / 白名单
+	probe    chan struct{} // 开启 --test-expiry 时，收到探测键的过期事件后通知
+	health   chan string   // 开启 --pubsub-health-check-interval 时，收到金丝雀键的过期事件后发送键名
+	limiter  *rate.Limiter // 开启 --max-events-per-second 时限制写入速率
+	ttls     *ttlBucketer  // 开启 --key-ttl-bucket 时使用
+	sinks    []EventSink   // 除过期键文件外，事件还要写入的目标 (例如 --stream-sink)
+	exporter *keyExporter  // 开启 --key-value-export-before-expiry 时使用
+
+	// --key-store-backend=postgres 时代替过期键文件，为 nil 时写入文件
+	pgStore *PostgresKeyStore
+}
+
+// 处理 events 中的过期事件，直到 events 被关闭
+func (h *eventHandler) run(ctx context.Context, events <-chan ExpiredEvent) {
+	for ev := range events {
+		// 探测键只用于验证订阅，不写入文件
+		if h.probe != nil && ev.Key == expiryProbeKey {
+			select {
+			case h.probe <- struct{}{}:
+			default:
+			}
+			continue
+		}
+		if h.health != nil && strings.HasPrefix(ev.Key, healthCheckPrefix) {
+			select {
+			case h.health <- ev.Key:
+			default:
+			}
+			continue
+		}
+
+		// --patterns 可能订阅了 del 等其他事件，只有过期事件写入文件
+		if name := eventName(ev.Channel); name != "" && name != "expired" {
+			if name == "set" {
+				if h.cfg.TrackReSets {
+					h.handleReSet(ev)
+				}
+				db, ok := parseChannelDB(ev.Channel)
+				if !ok {
+					db = h.cfg.DB
+				}
+				h.exporter.Schedule(ctx, ev.Key, db)
+			}
+			h.handleOther(name, ev)
+			continue
+		}
+
+		// 超过 --max-events-per-second 的事件照常从 channel 中取出 (避免阻塞 go-redis)，但直接丢弃
+		if h.limiter != nil && !h.limiter.Allow() {
+			h.metrics.Count(metricEventsRateLimited, 1, nil)
+			continue
+		}
+
+		log.Printf("Receive Key expired: %s\n", ev.Key) // 打印过期的键名
+		h.metrics.Count(metricKeysReceived, 1, nil)
+		if h.cfg.KeySampleLog > 0 && rand.Float64() < h.cfg.KeySampleLog {
+			h.logSample(ev)
+		}
+		if h.analyzer != nil {
+			h.analyzer.Observe(ev.Key)
+		}
+		h.prefixes.Observe(ev.Key)
+
+		// 记录过期键到文件，按 --trace-sample-rate 采样追踪
+		evCtx, span := otel.Tracer(tracerName).Start(ctx, spanKeyExpiry, trace.WithAttributes(attribute.String("key", ev.Key), attribute.String("channel", ev.Channel)))
+		if err := h.handle(evCtx, ev); err != nil {
+			log.Fatalf("Failed to write expired key to file: %v", err)
+		}
+		span.End()
+	}
+}
+
+// 处理过期以外的 keyspace 事件，目前只计数
+func (h *eventHandler) handleOther(name string, ev ExpiredEvent) {
+	debugf("Receive %s event for key %s on %s", name, ev.Key, ev.Channel)
+	h.metrics.Count(metricKeyEvents, 1, map[string]string{"event": name})
+}
+
+// 按 --key-sample-log 的采样率输出一条可读的过期事件日志
+func (h *eventHandler) logSample(ev ExpiredEvent) {
+	db, ok := parseChannelDB(ev.Channel)
+	if !ok {
+		db = h.cfg.DB
+	}
+	log.Printf("Sampled expired key: key=%q db=%d channel=%s received=%s",
+		ev.Key, db, ev.Channel, time.Now().Format(time.RFC3339Nano))
+}
+
+func (h *eventHandler) handle(ctx context.Context, ev ExpiredEvent) error {
+	// 窗口内已经写入过的键不再重复写入
+	if d, ok := h.dedup.(*redisDedup); ok && d.isSentinel(ev.Key) {
+		return nil
+	}
+	if h.dedup != nil && h.dedup.seenRecently(ctx, ev, time.Now()) {
+		debugf("Skipping duplicate expired key %s", ev.Key)
+		return nil
+	}
+
+	if !h.filter.Allow(ev.Key) {
+		debugf("Skipping filtered expired key %s", ev.Key)
+		return nil
+	}
+
+	db, ok := parseChannelDB(ev.Channel)
+	if !ok {
+		db = h.cfg.DB
+	}
+	rec := KeyRecord{Key: ev.Key, DB: db, TS: time.Now().Format(time.RFC3339Nano)}
+	if len(h.cfg.Tags) > 0 {
+		rec.Tags = h.cfg.Tags
+	}
+
+	// 伴随键每个事件只读取一次，GET 会重置它的空闲时间
+	var hint ttlHint
+	if h.cfg.EventMinTTL > 0 || h.cfg.KeyExpireHistogram || h.ttls != nil {
+		hint = h.readTTLHint(ctx, ev.Key)
+	}
+
+	// 原始 TTL 低于阈值的短命键 (如限流令牌) 不需要惰性删除
+	if h.cfg.EventMinTTL > 0 && h.shortLived(hint) {
+		debugf("Skipping short-lived expired key %s", ev.Key)
+		return nil
+	}
+
+	if h.cfg.MetaHashPrefix != "" && h.cfg.Format == formatJSON {
+		rec.Meta = h.lookupMeta(ctx, ev.Key)
+	}
+
+	if h.cfg.KeyExpireHistogram {
+		h.observeTTL(ev.Key, hint)
+	}
+	h.ttls.Observe(ev.Key, hint)
+
+	if h.cfg.CaptureExpiryTime {
+		rec.ExpireMethod = ev.Method
+		rec.ExpireTime = h.expireTime(ctx, ev.Key)
+	}
+
+	h.broker.Publish(rec)
+
+	// 额外的输出失败只打印日志，过期键文件仍然照常写入
+	for _, sink := range h.sinks {
+		if err := sink.Write(ctx, rec); err != nil {
+			log.Printf("Failed to write expired key %s to sink: %v", rec.Key, err)
+		}
+	}
+
+	if h.pgStore != nil {
+		return h.pgStore.Append(rec)
+	}
+	if h.dbStore != nil {
+		return h.dbStore.Append(rec)
+	}
+	return h.store.Append(rec)
+}
+
+// ttlHint 是伴随键 <ttl-hint-prefix><key> 的内容
+type ttlHint struct {
+	found   bool          // 伴随键存在
+	idle    time.Duration // 伴随键的 OBJECT IDLETIME
+	seconds int64         // 伴随键的值，即原定的 TTL 秒数
+	valid   bool          // 伴随键的值是整数
+}
+
+// 收到过期事件时键的 TTL 已经是 -2，因此原始 TTL 需要由应用在 SET 时
+// 额外写入伴随键 <ttl-hint-prefix><key>，值为原始 TTL 秒数，且其过期时间应略长于原键。
+// 伴随键与原键同时写入且之后不再访问，它的 OBJECT IDLETIME 近似等于原键写入至今的时间。
+// 先取 IDLETIME 再 GET，GET 会重置空闲时间
+func (h *eventHandler) readTTLHint(ctx context.Context, key string) ttlHint {
+	hint := h.cfg.TTLHintPrefix + key
+	var idle *redis.DurationCmd
+	var value *redis.StringCmd
+	_, err := h.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		idle = pipe.ObjectIdleTime(ctx, hint)
+		value = pipe.Get(ctx, hint)
+		return nil
+	})
+	if err != nil {
+		if err != redis.Nil {
+			debugf("Failed to get TTL hint of %s: %v", key, err)
+		}
+		return ttlHint{}
+	}
+	seconds, err := value.Int64()
+	return ttlHint{found: true, idle: idle.Val(), seconds: seconds, valid: err == nil}
+}
+
+// 伴随键不存在时无法判断，按普通键处理
+func (h *eventHandler) shortLived(hint ttlHint) bool {
+	if !hint.found || !hint.valid {
+		return false
+	}
+	return time.Duration(hint.seconds)*time.Second < h.cfg.EventMinTTL
+}
+
+// 读取 <prefix><key> 哈希中的元数据，哈希不存在或超时时返回 nil
+func (h *eventHandler) lookupMeta(ctx context.Context, key string) map[string]string {
+	if h.cfg.MetaTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.MetaTimeout)
+		defer cancel()
+	}
+
+	meta, err := h.rdb.HGetAll(ctx, h.cfg.MetaHashPrefix+key).Result()
+	if err != nil {
+		debugf("Failed to get metadata of key %s: %v", key, err)
+		return nil
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	return meta
+}
+
+// 调用 EXPIRETIME (Redis 7.0+) 获取键的过期时间。收到过期事件时键通常已经不存在，
+// EXPIRETIME 返回 -2，此时只记录这次尝试
+func (h *eventHandler) expireTime(ctx context.Context, key string) int64 {
+	ts, err := h.rdb.Do(ctx, "EXPIRETIME", key).Int64()
+	if err != nil {
+		debugf("Failed to get expire time of key %s: %v", key, err)
+		return 0
+	}
+	debugf("EXPIRETIME %s returned %d", key, ts)
+	if ts < 0 {
+		return 0
+	}
+	return ts
+}
+
+// 根据伴随键的空闲时间记录键的实际存活时间
+func (h *eventHandler) observeTTL(key string, hint ttlHint) {
+	if !hint.found {
+		return
+	}
+	actual := hint.idle
+	h.metrics.Observe(metricKeyTTL, actual.Seconds(), nil)
+	if hint.valid && hint.seconds > 0 {
+		drift := (actual.Seconds() - float64(hint.seconds)) / float64(hint.seconds) * 100
+		debugf("key %s lived %v, intended %ds (drift %.1f%%)", key, actual, hint.seconds, drift)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// recordingBackend 记录所有直方图观测值
+type recordingBackend struct {
+	mu       sync.Mutex
+	observed map[string][]float64
+}
+
+func (b *recordingBackend) Count(string, int64, map[string]string)   {}
+func (b *recordingBackend) Gauge(string, float64, map[string]string) {}
+func (b *recordingBackend) Observe(name string, value float64, _ map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.observed[name] = append(b.observed[name], value)
+}
+
+// --event-min-ttl、--key-expire-histogram 和 --key-ttl-bucket 同时开启时，
+// 伴随键只读取一次，且 IDLETIME 在 GET 之前读取，三者看到的都是写入至今的时间
+func TestHandleReadsTTLHintOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	start := time.Now()
+	mr.SetTime(start)
+	mr.Set("ttl:session:1", "600")
+	mr.SetTime(start.Add(30 * time.Second))
+
+	backend := &recordingBackend{observed: make(map[string][]float64)}
+	metrics := NewMetrics(backend)
+	cfg := &Config{
+		KeyFile:            filepath.Join(t.TempDir(), "expired_keys.txt"),
+		Format:             formatText,
+		TTLHintPrefix:      "ttl:",
+		EventMinTTL:        time.Minute,
+		KeyExpireHistogram: true,
+	}
+	h := &eventHandler{
+		cfg:     cfg,
+		rdb:     rdb,
+		store:   NewFileKeyStore(cfg.KeyFile, cfg.Format),
+		metrics: metrics,
+		ttls:    newTTLBucketer(metrics),
+	}
+
+	before := mr.CommandCount()
+	if err := h.handle(context.Background(), ExpiredEvent{Key: "session:1", Channel: expiredChannel}); err != nil {
+		t.Fatalf("handle() error = %v", err)
+	}
+	// OBJECT IDLETIME 和 GET 各一次
+	if n := mr.CommandCount() - before; n != 2 {
+		t.Errorf("handle sent %d commands, want 2", n)
+	}
+	if got := backend.observed[metricKeyTTL]; len(got) != 1 || got[0] != 30 {
+		t.Errorf("%s = %v, want [30]", metricKeyTTL, got)
+	}
+	if got := backend.observed[metricKeyTTLBucket]; len(got) != 1 || got[0] != 600 {
+		t.Errorf("%s = %v, want [600]", metricKeyTTLBucket, got)
+	}
+}
+
+// 原定 TTL 低于 --event-min-ttl 的键不写入过期键文件，伴随键不存在时按普通键处理
+func TestShortLived(t *testing.T) {
+	h := &eventHandler{cfg: &Config{EventMinTTL: time.Minute}}
+	tests := []struct {
+		name string
+		hint ttlHint
+		want bool
+	}{
+		{"no hint", ttlHint{}, false},
+		{"short", ttlHint{found: true, seconds: 10, valid: true}, true},
+		{"long", ttlHint{found: true, seconds: 600, valid: true}, false},
+		{"not a number", ttlHint{found: true}, false},
+	}
+	for _, tt := range tests {
+		if got := h.shortLived(tt.hint); got != tt.want {
+			t.Errorf("%s: shortLived() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// 执行外部脚本，env 追加到当前进程的环境变量之后，输出直接转发到本进程
+func runHook(ctx context.Context, path string, env []string) error {
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,335 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// 集成测试连接 testcontainers 启动的 Redis 7，需要本机可以运行 Docker：
+//
+//	go test -tags integration -run Integration -v .
+
+// TestMain 启动的 Redis 容器地址 (host:port)
+var redisAddr string
+
+func TestMain(m *testing.M) {
+	// TestE2EWriteFailure 的子进程不需要容器
+	if os.Getenv(e2eHelperEnv) == "1" {
+		os.Exit(m.Run())
+	}
+
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:7-alpine",
+			ExposedPorts: []string{"6379/tcp"},
+			// DEBUG RESTART 和 DEBUG SET-ACTIVE-EXPIRE 需要开启 DEBUG 命令。
+			// 过期通知写在启动参数里，DEBUG RESTART 之后仍然有效
+			Cmd:        []string{"redis-server", "--enable-debug-command", "yes", "--notify-keyspace-events", "Ex"},
+			WaitingFor: wait.ForLog("Ready to accept connections"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start Redis container: %v", err)
+	}
+	redisAddr, err = container.Endpoint(ctx, "")
+	if err != nil {
+		container.Terminate(ctx)
+		log.Fatalf("Failed to get Redis container endpoint: %v", err)
+	}
+
+	code := m.Run()
+	if err := container.Terminate(ctx); err != nil {
+		log.Printf("Failed to terminate Redis container: %v", err)
+	}
+	os.Exit(code)
+}
+
+// 集成测试的默认配置：订阅 db 0 的过期事件，纯文本过期键文件，清理时用 TYPE 触发惰性删除
+func newIntegrationConfig(keyFile string) *Config {
+	return &Config{
+		KeyFile:          keyFile,
+		Format:           formatText,
+		CompatMode:       "pubsub",
+		ChannelPattern:   "__keyevent@%d__:expired",
+		DeletionStrategy: strategyType,
+		IgnoreErrors:     true,
+	}
+}
+
+// 连接 addr 上的 Redis，按 cfg 订阅过期事件并写入过期键文件，组装方式与 main 相同，dedup 为 nil 时不去重
+func startPipeline(t *testing.T, addr string, cfg *Config, dedup deduper) *e2eRun {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store := NewFileKeyStore(cfg.KeyFile, cfg.Format)
+	collector := newEventCollector(ctx, rdb, cfg, nil)
+	events := make(chan ExpiredEvent, 100)
+	go func() {
+		defer close(events)
+		collector.Collect(ctx, events)
+	}()
+	handler := &eventHandler{cfg: cfg, rdb: rdb, store: store, dedup: dedup}
+	go handler.run(ctx, events)
+
+	strategy, err := newDeletionStrategy(cfg.DeletionStrategy)
+	if err != nil {
+		t.Fatalf("newDeletionStrategy: %v", err)
+	}
+	return &e2eRun{
+		rdb:     rdb,
+		store:   store,
+		cleaner: &Cleaner{rdb: rdb, store: store, cfg: cfg, strategy: strategy},
+	}
+}
+
+// 清空容器中的数据，恢复默认配置，返回一个直连的客户端
+func resetRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx := context.Background()
+	if err := rdb.FlushAll(ctx).Err(); err != nil {
+		t.Fatalf("FLUSHALL: %v", err)
+	}
+	if err := rdb.ConfigSet(ctx, "notify-keyspace-events", "Ex").Err(); err != nil {
+		t.Fatalf("CONFIG SET notify-keyspace-events: %v", err)
+	}
+	if err := rdb.Do(ctx, "debug", "set-active-expire", "1").Err(); err != nil {
+		t.Fatalf("DEBUG SET-ACTIVE-EXPIRE: %v", err)
+	}
+	return rdb
+}
+
+// 设置 ttl 后过期的键
+func setWithTTL(t *testing.T, rdb *redis.Client, ttl time.Duration, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := rdb.Set(context.Background(), key, "v", ttl).Err(); err != nil {
+			t.Fatalf("SET %s: %v", key, err)
+		}
+	}
+}
+
+// (1) 过期事件被接收并写入文件。过期通知被关闭时由启动流程重新开启
+func TestIntegrationExpiryWrittenToFile(t *testing.T) {
+	rdb := resetRedis(t)
+	ctx := context.Background()
+	if err := rdb.ConfigSet(ctx, "notify-keyspace-events", "").Err(); err != nil {
+		t.Fatalf("CONFIG SET notify-keyspace-events: %v", err)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "expired_keys.txt")
+	startPipeline(t, redisAddr, newIntegrationConfig(keyFile), nil)
+
+	if flags, err := rdb.ConfigGet(ctx, "notify-keyspace-events").Result(); err != nil || len(flags) != 2 || !strings.Contains(flags[1].(string), "E") {
+		t.Fatalf("notify-keyspace-events = %v (err %v), want expired notifications enabled", flags, err)
+	}
+
+	setWithTTL(t, rdb, 100*time.Millisecond, "session:1", "session:2")
+	setWithTTL(t, rdb, 0, "config:1")
+	if lines := waitForKeys(t, keyFile, "session:1", "session:2"); len(lines) != 2 {
+		t.Errorf("key file = %q, want only the expired keys", lines)
+	}
+}
+
+// (2) 去重窗口内重复收到的过期事件只写入一次
+func TestIntegrationDuplicateEventDedup(t *testing.T) {
+	rdb := resetRedis(t)
+	ctx := context.Background()
+
+	keyFile := filepath.Join(t.TempDir(), "expired_keys.txt")
+	startPipeline(t, redisAddr, newIntegrationConfig(keyFile), newDedupCache(time.Minute, 1000))
+
+	setWithTTL(t, rdb, 100*time.Millisecond, "session:1")
+	waitForKeys(t, keyFile, "session:1")
+
+	// 模拟重复投递 (例如故障转移前后各收到一次)
+	channel := channelName("__keyevent@%d__:expired", 0)
+	for i := 0; i < 3; i++ {
+		if err := rdb.Publish(ctx, channel, "session:1").Err(); err != nil {
+			t.Fatalf("PUBLISH: %v", err)
+		}
+	}
+	// 事件按顺序处理，标记键写入时前面的重复事件已经处理完
+	setWithTTL(t, rdb, 100*time.Millisecond, "marker")
+	lines := waitForKeys(t, keyFile, "marker")
+	if n := strings.Count(strings.Join(lines, "\n")+"\n", "session:1\n"); n != 1 {
+		t.Errorf("session:1 written %d times, want 1: %q", n, lines)
+	}
+}
+
+// (3) 清理时访问文件中的键，触发 Redis 删除已经逻辑过期但仍占用内存的键
+func TestIntegrationCleanupDeletesKeys(t *testing.T) {
+	rdb := resetRedis(t)
+	ctx := context.Background()
+
+	keyFile := filepath.Join(t.TempDir(), "expired_keys.txt")
+	cfg := newIntegrationConfig(keyFile)
+	// 只测试清理：订阅一个不会收到事件的频道，过期键由测试写入文件
+	cfg.ChannelPattern = "__keyevent@%d__:unused"
+	e := startPipeline(t, redisAddr, cfg, nil)
+
+	// 关闭主动过期后，过期的键要等到被访问时才会删除
+	if err := rdb.Do(ctx, "debug", "set-active-expire", "0").Err(); err != nil {
+		t.Fatalf("DEBUG SET-ACTIVE-EXPIRE: %v", err)
+	}
+	keys := []string{"session:1", "session:2", "session:3"}
+	setWithTTL(t, rdb, 50*time.Millisecond, keys...)
+	setWithTTL(t, rdb, 0, "config:1")
+	for _, key := range keys {
+		if err := e.store.Append(KeyRecord{Key: key}); err != nil {
+			t.Fatalf("Append %s: %v", key, err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n, err := rdb.DBSize(ctx).Result(); err != nil || n != 4 {
+		t.Fatalf("DBSIZE before cleanup = %d (err %v), want 4 (expired keys not reclaimed yet)", n, err)
+	}
+
+	if err := e.cleaner.runCleanup(ctx); err != nil {
+		t.Fatalf("runCleanup: %v", err)
+	}
+	if stats := e.cleaner.lastStats; stats.processed != 3 || stats.deleted != 3 || stats.errors != 0 {
+		t.Errorf("stats = processed %d, deleted %d, errors %d, want 3, 3, 0", stats.processed, stats.deleted, stats.errors)
+	}
+	if n, err := rdb.DBSize(ctx).Result(); err != nil || n != 1 {
+		t.Errorf("DBSIZE after cleanup = %d (err %v), want 1", n, err)
+	}
+	if data, err := os.ReadFile(keyFile); err != nil || len(data) != 0 {
+		t.Errorf("key file after cleanup = %q (err %v), want empty", data, err)
+	}
+}
+
+// (4) Redis 重启之后重新订阅，继续接收过期事件
+func TestIntegrationReconnectAfterRestart(t *testing.T) {
+	rdb := resetRedis(t)
+	ctx := context.Background()
+
+	keyFile := filepath.Join(t.TempDir(), "expired_keys.txt")
+	startPipeline(t, redisAddr, newIntegrationConfig(keyFile), nil)
+	setWithTTL(t, rdb, 100*time.Millisecond, "before")
+	waitForKeys(t, keyFile, "before")
+
+	// DEBUG RESTART 以相同的参数重新执行 redis-server，容器和端口不变；Redis 关闭连接，不会回复
+	rdb.Do(ctx, "debug", "restart")
+	deadline := time.Now().Add(30 * time.Second)
+	for rdb.Ping(ctx).Err() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Redis did not come back after DEBUG RESTART")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// go-redis 在后台重连并重新订阅，重新订阅之前过期的键收不到事件，所以反复设置新的键
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("after:%d", i)
+		setWithTTL(t, rdb, 50*time.Millisecond, key)
+		time.Sleep(200 * time.Millisecond)
+		data, _ := os.ReadFile(keyFile)
+		if strings.Contains(string(data), "after:") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no expiry events after the restart, key file = %q", data)
+		}
+	}
+}
+
+// (5) 启动时 Redis 不可用，waitForRedis 按退避重试，Redis 可用后返回
+func TestIntegrationStartupRetry(t *testing.T) {
+	resetRedis(t)
+
+	// 先占用一个端口再释放，Redis "启动" 之前连接该端口会被拒绝
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// 500ms 后在该端口上启动转发到容器的代理
+	proxyc := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		proxy, err := net.Listen("tcp", addr)
+		if err != nil {
+			proxyc <- nil
+			return
+		}
+		proxyc <- proxy
+		forward(proxy, redisAddr)
+	}()
+	t.Cleanup(func() {
+		if proxy := <-proxyc; proxy != nil {
+			proxy.Close()
+		}
+	})
+
+	// 关闭 go-redis 的命令重试，每次 PING 对应 waitForRedis 的一次尝试
+	client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	rdb := &pingCounter{RedisClient: client}
+	start := time.Now()
+	err = waitForRedis(context.Background(), rdb, 20, &BackoffPolicy{Initial: 100 * time.Millisecond, Max: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("waitForRedis: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("waitForRedis returned after %v, before Redis was available", elapsed)
+	}
+	if rdb.pings < 2 {
+		t.Errorf("waitForRedis pinged %d times, want retries", rdb.pings)
+	}
+}
+
+// pingCounter 记录 PING 的次数
+type pingCounter struct {
+	RedisClient
+	pings int
+}
+
+func (c *pingCounter) Ping(ctx context.Context) *redis.StatusCmd {
+	c.pings++
+	return c.RedisClient.Ping(ctx)
+}
+
+// 将 ln 上的连接转发到 target，直到 ln 被关闭
+func forward(ln net.Listener, target string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				return
+			}
+			defer upstream.Close()
+			go io.Copy(upstream, conn)
+			io.Copy(conn, upstream)
+		}()
+	}
+}
@@ -0,0 +1,82 @@
+// Package humanize 将数量、字节数和时长格式化为便于阅读的字符串
+package humanize
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	siUnits  = []string{"", "k", "M", "G", "T", "P", "E"}
+	iecUnits = []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+)
+
+// HumanizeInt 使用 SI 前缀格式化数量，保留一位小数，例如 1234567 -> "1.2M"，999 -> "999"
+func HumanizeInt(n int64) string {
+	sign, abs := splitSign(n)
+	if abs < 1000 {
+		return fmt.Sprintf("%s%d", sign, abs)
+	}
+	return sign + scale(float64(abs), 1000, siUnits)
+}
+
+// HumanizeBytes 按 1024 进制格式化字节数，保留一位小数，例如 3456789012 -> "3.2GB"，512 -> "512B"
+func HumanizeBytes(b int64) string {
+	sign, abs := splitSign(b)
+	if abs < 1024 {
+		return fmt.Sprintf("%s%dB", sign, abs)
+	}
+	return sign + scale(float64(abs), 1024, iecUnits)
+}
+
+// HumanizeDuration 按时、分、秒格式化时长，省略为 0 的部分，例如 3661s -> "1h 1m 1s"。
+// 不足 1 秒的时长使用 time.Duration 自身的格式，例如 "250ms"，超过 1 秒时舍去不足 1 秒的部分
+func HumanizeDuration(d time.Duration) string {
+	if d < 0 {
+		// -d 在 d 为最小值时会溢出，多出的 1 纳秒不影响结果
+		if d == math.MinInt64 {
+			d++
+		}
+		return "-" + HumanizeDuration(-d)
+	}
+	if d < time.Second {
+		return d.String()
+	}
+
+	h := d / time.Hour
+	m := d % time.Hour / time.Minute
+	s := d % time.Minute / time.Second
+
+	var parts []string
+	if h > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", h))
+	}
+	if m > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", m))
+	}
+	if s > 0 {
+		parts = append(parts, fmt.Sprintf("%ds", s))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 拆出符号，返回绝对值，兼容 math.MinInt64
+func splitSign(n int64) (string, uint64) {
+	if n < 0 {
+		return "-", uint64(-(n + 1)) + 1
+	}
+	return "", uint64(n)
+}
+
+// 按 base 逐级换算单位，四舍五入后达到 base 时进位到下一级，并去掉多余的 ".0"
+func scale(v, base float64, units []string) string {
+	i := 0
+	for v >= base-0.05 && i < len(units)-1 {
+		v /= base
+		i++
+	}
+	s := fmt.Sprintf("%.1f", v)
+	return strings.TrimSuffix(s, ".0") + units[i]
+}
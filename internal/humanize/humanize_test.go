@@ -0,0 +1,100 @@
+package humanize
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHumanizeInt(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{999, "999"},
+		{1000, "1k"},
+		{1049, "1k"},
+		{1234, "1.2k"},
+		{999_900, "999.9k"},
+		{999_999, "1M"}, // 四舍五入到 1000.0k 时进位
+		{1_000_000, "1M"},
+		{1_234_567, "1.2M"},
+		{1_000_000_000, "1G"},
+		{1_000_000_000_000_000_000, "1E"},
+		{math.MaxInt64, "9.2E"},
+		{-1, "-1"},
+		{-999, "-999"},
+		{-1000, "-1k"},
+		{-1_234_567, "-1.2M"},
+		{math.MinInt64, "-9.2E"},
+	}
+	for _, tt := range tests {
+		if got := HumanizeInt(tt.n); got != tt.want {
+			t.Errorf("HumanizeInt(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestHumanizeBytes(t *testing.T) {
+	tests := []struct {
+		b    int64
+		want string
+	}{
+		{0, "0B"},
+		{1, "1B"},
+		{1023, "1023B"},
+		{1024, "1KB"},
+		{1536, "1.5KB"},
+		{1<<20 - 1, "1MB"}, // 四舍五入到 1024.0KB 时进位
+		{1 << 20, "1MB"},
+		{3_456_789_012, "3.2GB"},
+		{1 << 40, "1TB"},
+		{1 << 50, "1PB"},
+		{1 << 60, "1EB"},
+		{math.MaxInt64, "8EB"},
+		{-1, "-1B"},
+		{-1023, "-1023B"},
+		{-1024, "-1KB"},
+		{-3_456_789_012, "-3.2GB"},
+		{math.MinInt64, "-8EB"},
+	}
+	for _, tt := range tests {
+		if got := HumanizeBytes(tt.b); got != tt.want {
+			t.Errorf("HumanizeBytes(%d) = %q, want %q", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{time.Nanosecond, "1ns"},
+		{250 * time.Millisecond, "250ms"},
+		{time.Second - time.Nanosecond, "999.999999ms"},
+		{time.Second, "1s"},
+		{1500 * time.Millisecond, "1s"},
+		{59 * time.Second, "59s"},
+		{time.Minute, "1m"},
+		{61 * time.Second, "1m 1s"},
+		{time.Hour, "1h"},
+		{3601 * time.Second, "1h 1s"},
+		{3661 * time.Second, "1h 1m 1s"},
+		{25 * time.Hour, "25h"},
+		{math.MaxInt64, "2562047h 47m 16s"},
+		{-time.Nanosecond, "-1ns"},
+		{-250 * time.Millisecond, "-250ms"},
+		{-time.Second, "-1s"},
+		{-3661 * time.Second, "-1h 1m 1s"},
+		{math.MinInt64, "-2562047h 47m 16s"},
+	}
+	for _, tt := range tests {
+		if got := HumanizeDuration(tt.d); got != tt.want {
+			t.Errorf("HumanizeDuration(%d) = %q, want %q", int64(tt.d), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package testutil 提供单元测试使用的辅助类型
+package testutil
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrPipelineUnsupported 在未通过 ForceError 指定 pipeline 错误时由 Pipelined 返回
+var ErrPipelineUnsupported = errors.New("testutil: pipelines are not supported by FakeRedisClient")
+
+// FakeRedisClient 是不需要网络连接的 RedisClient 实现，按预先配置的结果响应命令。
+// 未配置的键视为不存在：TYPE 返回 none，DEL 返回 0，OBJECT 返回 redis.Nil。
+// 所有命令在 ctx 已取消时返回 ctx.Err()
+type FakeRedisClient struct {
+	opts redis.Options
+
+	mu    sync.Mutex
+	types map[string]string
+	dels  map[string]int64
+	errs  map[string]error
+	calls []string
+}
+
+// NewFakeRedisClient 创建一个模拟 db 号数据库的 FakeRedisClient
+func NewFakeRedisClient(db int) *FakeRedisClient {
+	return &FakeRedisClient{
+		opts:  redis.Options{Addr: "fake:6379", DB: db},
+		types: make(map[string]string),
+		dels:  make(map[string]int64),
+		errs:  make(map[string]error),
+	}
+}
+
+// AddTypeResponse 设置 TYPE key 的返回值，同时让 key 出现在 SCAN 结果中
+func (f *FakeRedisClient) AddTypeResponse(key, typeName string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.types[key] = typeName
+}
+
+// AddDelResponse 设置 DEL key 返回的删除数量
+func (f *FakeRedisClient) AddDelResponse(key string, deleted int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dels[key] = deleted
+}
+
+// ForceError 让 cmd 命令 (小写，如 "type"、"del"、"scan"、"pipeline") 对 key 返回 err，
+// key 为空时对该命令的所有调用生效
+func (f *FakeRedisClient) ForceError(cmd, key string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs[cmd+"\x00"+key] = err
+}
+
+// Calls 返回按调用顺序记录的命令，例如 "type session:1"
+func (f *FakeRedisClient) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// 记录一次调用，并返回 ctx 错误或预先指定的错误
+func (f *FakeRedisClient) call(ctx context.Context, cmd, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, cmd+" "+key)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err, ok := f.errs[cmd+"\x00"+key]; ok {
+		return err
+	}
+	return f.errs[cmd+"\x00"]
+}
+
+func (f *FakeRedisClient) Options() *redis.Options {
+	return &f.opts
+}
+
+func (f *FakeRedisClient) Ping(ctx context.Context) *redis.StatusCmd {
+	if err := f.call(ctx, "ping", ""); err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func (f *FakeRedisClient) Type(ctx context.Context, key string) *redis.StatusCmd {
+	if err := f.call(ctx, "type", key); err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if typeName, ok := f.types[key]; ok {
+		return redis.NewStatusResult(typeName, nil)
+	}
+	return redis.NewStatusResult("none", nil)
+}
+
+func (f *FakeRedisClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	var deleted int64
+	for _, key := range keys {
+		if err := f.call(ctx, "del", key); err != nil {
+			return redis.NewIntResult(0, err)
+		}
+		f.mu.Lock()
+		deleted += f.dels[key]
+		f.mu.Unlock()
+	}
+	return redis.NewIntResult(deleted, nil)
+}
+
+// Unlink 与 Del 使用相同的配置结果
+func (f *FakeRedisClient) Unlink(ctx context.Context, keys ...string) *redis.IntCmd {
+	var deleted int64
+	for _, key := range keys {
+		if err := f.call(ctx, "unlink", key); err != nil {
+			return redis.NewIntResult(0, err)
+		}
+		f.mu.Lock()
+		deleted += f.dels[key]
+		f.mu.Unlock()
+	}
+	return redis.NewIntResult(deleted, nil)
+}
+
+// HDel 返回删除的字段数，错误通过 ForceError("hdel", key, err) 指定
+func (f *FakeRedisClient) HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd {
+	if err := f.call(ctx, "hdel", key); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return redis.NewIntResult(int64(len(fields)), nil)
+}
+
+func (f *FakeRedisClient) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	var n int64
+	for _, key := range keys {
+		if err := f.call(ctx, "exists", key); err != nil {
+			return redis.NewIntResult(0, err)
+		}
+		if f.exists(key) {
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
+func (f *FakeRedisClient) ObjectEncoding(ctx context.Context, key string) *redis.StringCmd {
+	if err := f.call(ctx, "object encoding", key); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	if !f.exists(key) {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult("raw", nil)
+}
+
+func (f *FakeRedisClient) ObjectRefCount(ctx context.Context, key string) *redis.IntCmd {
+	if err := f.call(ctx, "object refcount", key); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	if !f.exists(key) {
+		return redis.NewIntResult(0, redis.Nil)
+	}
+	return redis.NewIntResult(1, nil)
+}
+
+// Scan 一次返回所有通过 AddTypeResponse 配置的键 (按键名排序)，match 和 count 被忽略
+func (f *FakeRedisClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
+	if err := f.call(ctx, "scan", match); err != nil {
+		return redis.NewScanCmdResult(nil, 0, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.types))
+	for key := range f.types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return redis.NewScanCmdResult(keys, 0, nil)
+}
+
+func (f *FakeRedisClient) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
+	if err := f.call(ctx, "script load", ""); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	return redis.NewStringResult("fake-sha", nil)
+}
+
+// EvalSha 模拟条件删除脚本：键不存在时返回 1，存在时返回 0
+func (f *FakeRedisClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	var key string
+	if len(keys) > 0 {
+		key = keys[0]
+	}
+	if err := f.call(ctx, "evalsha", key); err != nil {
+		return redis.NewCmdResult(nil, err)
+	}
+	if f.exists(key) {
+		return redis.NewCmdResult(int64(0), nil)
+	}
+	return redis.NewCmdResult(int64(1), nil)
+}
+
+// Pipelined 不执行 fn，返回 ForceError("pipeline", "", err) 指定的错误或 ErrPipelineUnsupported
+func (f *FakeRedisClient) Pipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	if err := f.call(ctx, "pipeline", ""); err != nil {
+		return nil, err
+	}
+	return nil, ErrPipelineUnsupported
+}
+
+// TxPipelined 与 Pipelined 相同，错误通过 ForceError("multi", "", err) 指定
+func (f *FakeRedisClient) TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	if err := f.call(ctx, "multi", ""); err != nil {
+		return nil, err
+	}
+	return nil, ErrPipelineUnsupported
+}
+
+func (f *FakeRedisClient) exists(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	typeName, ok := f.types[key]
+	return ok && typeName != "none"
+}
@@ -0,0 +1,4 @@
+// Package keyeventpb 包含 KeyEventService 的 protobuf 定义和生成代码
+package keyeventpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative keyevent.proto
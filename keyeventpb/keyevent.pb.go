@@ -0,0 +1,222 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: keyevent.proto
+
+package keyeventpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StreamRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *StreamRequest) Reset() {
+	*x = StreamRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_keyevent_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamRequest) ProtoMessage() {}
+
+func (x *StreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_keyevent_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamRequest.ProtoReflect.Descriptor instead.
+func (*StreamRequest) Descriptor() ([]byte, []int) {
+	return file_keyevent_proto_rawDescGZIP(), []int{0}
+}
+
+type KeyEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Db  int32  `protobuf:"varint,2,opt,name=db,proto3" json:"db,omitempty"`
+	Ts  string `protobuf:"bytes,3,opt,name=ts,proto3" json:"ts,omitempty"` // RFC 3339 格式的接收时间
+}
+
+func (x *KeyEvent) Reset() {
+	*x = KeyEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_keyevent_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *KeyEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyEvent) ProtoMessage() {}
+
+func (x *KeyEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_keyevent_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyEvent.ProtoReflect.Descriptor instead.
+func (*KeyEvent) Descriptor() ([]byte, []int) {
+	return file_keyevent_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *KeyEvent) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *KeyEvent) GetDb() int32 {
+	if x != nil {
+		return x.Db
+	}
+	return 0
+}
+
+func (x *KeyEvent) GetTs() string {
+	if x != nil {
+		return x.Ts
+	}
+	return ""
+}
+
+var File_keyevent_proto protoreflect.FileDescriptor
+
+var file_keyevent_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x6b, 0x65, 0x79, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x08, 0x6b, 0x65, 0x79, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x22, 0x0f, 0x0a, 0x0d, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x3c, 0x0a, 0x08, 0x4b,
+	0x65, 0x79, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x0e, 0x0a, 0x02, 0x64, 0x62, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02, 0x64, 0x62, 0x12, 0x0e, 0x0a, 0x02, 0x74, 0x73, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x74, 0x73, 0x32, 0x55, 0x0a, 0x0f, 0x4b, 0x65, 0x79,
+	0x45, 0x76, 0x65, 0x6e, 0x74, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x42, 0x0a, 0x11,
+	0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x45, 0x78, 0x70, 0x69, 0x72, 0x65, 0x64, 0x4b, 0x65, 0x79,
+	0x73, 0x12, 0x17, 0x2e, 0x6b, 0x65, 0x79, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x53, 0x74, 0x72,
+	0x65, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x12, 0x2e, 0x6b, 0x65, 0x79,
+	0x65, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x4b, 0x65, 0x79, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x30, 0x01,
+	0x42, 0x3b, 0x5a, 0x39, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x52,
+	0x45, 0x53, 0x49, 0x44, 0x55, 0x41, 0x4c, 0x57, 0x41, 0x53, 0x54, 0x45, 0x2f, 0x52, 0x65, 0x64,
+	0x69, 0x73, 0x45, 0x78, 0x70, 0x69, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x73, 0x44, 0x65, 0x6c, 0x65,
+	0x74, 0x65, 0x2f, 0x6b, 0x65, 0x79, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x70, 0x62, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_keyevent_proto_rawDescOnce sync.Once
+	file_keyevent_proto_rawDescData = file_keyevent_proto_rawDesc
+)
+
+func file_keyevent_proto_rawDescGZIP() []byte {
+	file_keyevent_proto_rawDescOnce.Do(func() {
+		file_keyevent_proto_rawDescData = protoimpl.X.CompressGZIP(file_keyevent_proto_rawDescData)
+	})
+	return file_keyevent_proto_rawDescData
+}
+
+var file_keyevent_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_keyevent_proto_goTypes = []any{
+	(*StreamRequest)(nil), // 0: keyevent.StreamRequest
+	(*KeyEvent)(nil),      // 1: keyevent.KeyEvent
+}
+var file_keyevent_proto_depIdxs = []int32{
+	0, // 0: keyevent.KeyEventService.StreamExpiredKeys:input_type -> keyevent.StreamRequest
+	1, // 1: keyevent.KeyEventService.StreamExpiredKeys:output_type -> keyevent.KeyEvent
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_keyevent_proto_init() }
+func file_keyevent_proto_init() {
+	if File_keyevent_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_keyevent_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*StreamRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_keyevent_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*KeyEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_keyevent_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_keyevent_proto_goTypes,
+		DependencyIndexes: file_keyevent_proto_depIdxs,
+		MessageInfos:      file_keyevent_proto_msgTypes,
+	}.Build()
+	File_keyevent_proto = out.File
+	file_keyevent_proto_rawDesc = nil
+	file_keyevent_proto_goTypes = nil
+	file_keyevent_proto_depIdxs = nil
+}
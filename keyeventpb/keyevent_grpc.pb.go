@@ -0,0 +1,138 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: keyevent.proto
+
+package keyeventpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	KeyEventService_StreamExpiredKeys_FullMethodName = "/keyevent.KeyEventService/StreamExpiredKeys"
+)
+
+// KeyEventServiceClient is the client API for KeyEventService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type KeyEventServiceClient interface {
+	// StreamExpiredKeys 推送连接建立之后收到的全部过期键事件
+	StreamExpiredKeys(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (KeyEventService_StreamExpiredKeysClient, error)
+}
+
+type keyEventServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKeyEventServiceClient(cc grpc.ClientConnInterface) KeyEventServiceClient {
+	return &keyEventServiceClient{cc}
+}
+
+func (c *keyEventServiceClient) StreamExpiredKeys(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (KeyEventService_StreamExpiredKeysClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KeyEventService_ServiceDesc.Streams[0], KeyEventService_StreamExpiredKeys_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &keyEventServiceStreamExpiredKeysClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KeyEventService_StreamExpiredKeysClient interface {
+	Recv() (*KeyEvent, error)
+	grpc.ClientStream
+}
+
+type keyEventServiceStreamExpiredKeysClient struct {
+	grpc.ClientStream
+}
+
+func (x *keyEventServiceStreamExpiredKeysClient) Recv() (*KeyEvent, error) {
+	m := new(KeyEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// KeyEventServiceServer is the server API for KeyEventService service.
+// All implementations must embed UnimplementedKeyEventServiceServer
+// for forward compatibility
+type KeyEventServiceServer interface {
+	// StreamExpiredKeys 推送连接建立之后收到的全部过期键事件
+	StreamExpiredKeys(*StreamRequest, KeyEventService_StreamExpiredKeysServer) error
+	mustEmbedUnimplementedKeyEventServiceServer()
+}
+
+// UnimplementedKeyEventServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedKeyEventServiceServer struct {
+}
+
+func (UnimplementedKeyEventServiceServer) StreamExpiredKeys(*StreamRequest, KeyEventService_StreamExpiredKeysServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamExpiredKeys not implemented")
+}
+func (UnimplementedKeyEventServiceServer) mustEmbedUnimplementedKeyEventServiceServer() {}
+
+// UnsafeKeyEventServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KeyEventServiceServer will
+// result in compilation errors.
+type UnsafeKeyEventServiceServer interface {
+	mustEmbedUnimplementedKeyEventServiceServer()
+}
+
+func RegisterKeyEventServiceServer(s grpc.ServiceRegistrar, srv KeyEventServiceServer) {
+	s.RegisterService(&KeyEventService_ServiceDesc, srv)
+}
+
+func _KeyEventService_StreamExpiredKeys_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KeyEventServiceServer).StreamExpiredKeys(m, &keyEventServiceStreamExpiredKeysServer{stream})
+}
+
+type KeyEventService_StreamExpiredKeysServer interface {
+	Send(*KeyEvent) error
+	grpc.ServerStream
+}
+
+type keyEventServiceStreamExpiredKeysServer struct {
+	grpc.ServerStream
+}
+
+func (x *keyEventServiceStreamExpiredKeysServer) Send(m *KeyEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// KeyEventService_ServiceDesc is the grpc.ServiceDesc for KeyEventService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var KeyEventService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "keyevent.KeyEventService",
+	HandlerType: (*KeyEventServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamExpiredKeys",
+			Handler:       _KeyEventService_StreamExpiredKeys_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "keyevent.proto",
+}
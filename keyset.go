@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// 清理前备份时按键名去重的算法 (--key-dedup-algorithm)
+const (
+	dedupAlgorithmMap       = "map"        // Go 内置 map，精确，内存占用最大
+	dedupAlgorithmBloom     = "bloom"      // 布隆过滤器，内存固定，约 1% 的键被误判为重复而跳过
+	dedupAlgorithmRobinHood = "robin-hood" // 开放寻址的 Robin Hood 哈希表，精确，缓存友好
+)
+
+// 去重使用的算法，由 --key-dedup-algorithm 设置
+var keyDedupAlgorithm = dedupAlgorithmMap
+
+// Set 是去重用的键名集合
+type Set interface {
+	// Add 加入 key，返回 key 之前是否不在集合中
+	Add(key string) bool
+	Contains(key string) bool
+}
+
+// 按 algorithm 创建预计存放 expected 个键的集合
+func newKeySet(algorithm string, expected int) (Set, error) {
+	switch algorithm {
+	case dedupAlgorithmMap:
+		return mapSet(make(map[string]struct{}, expected)), nil
+	case dedupAlgorithmBloom:
+		return newBloomSet(expected, bloomFalsePositiveRate), nil
+	case dedupAlgorithmRobinHood:
+		return newRobinHoodSet(expected), nil
+	}
+	return nil, fmt.Errorf("unknown dedup algorithm %q (expected map, bloom or robin-hood)", algorithm)
+}
+
+// 根据文件大小估计记录数，按每条记录平均 32 字节计算，无法获取大小时返回较小的默认值
+func expectedRecords(r io.Reader) int {
+	const minExpected = 1024
+	file, ok := r.(*os.File)
+	if !ok {
+		return minExpected
+	}
+	info, err := file.Stat()
+	if err != nil || info.Size()/32 < minExpected {
+		return minExpected
+	}
+	return int(info.Size() / 32)
+}
+
+// 64 位 FNV-1a，不分配内存
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+type mapSet map[string]struct{}
+
+func (s mapSet) Add(key string) bool {
+	if _, ok := s[key]; ok {
+		return false
+	}
+	s[key] = struct{}{}
+	return true
+}
+
+func (s mapSet) Contains(key string) bool {
+	_, ok := s[key]
+	return ok
+}
+
+// 布隆过滤器的误判率。误判的键不会被写入备份，本次清理不会访问它，
+// 这些键已经过期，之后由 Redis 自己的主动过期删除
+const bloomFalsePositiveRate = 0.01
+
+// bloomSet 使用双重哈希 h1 + i*h2 模拟 k 个哈希函数
+type bloomSet struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+func newBloomSet(expected int, falsePositive float64) *bloomSet {
+	// m = -n ln(p) / (ln 2)^2，k = m/n ln 2
+	m := uint64(math.Ceil(-float64(expected) * math.Log(falsePositive) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(expected) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomSet{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// 由一个 64 位哈希得到双重哈希的两个值，h2 为奇数避免退化
+func (s *bloomSet) hashes(key string) (uint64, uint64) {
+	h := hashKey(key)
+	h2 := h>>32 | h<<32
+	h2 ^= h2 >> 29
+	h2 *= 0xbf58476d1ce4e5b9
+	return h, h2 | 1
+}
+
+func (s *bloomSet) Add(key string) bool {
+	h1, h2 := s.hashes(key)
+	added := false
+	for i := uint64(0); i < s.k; i++ {
+		bit := (h1 + i*h2) % s.m
+		if s.bits[bit/64]&(1<<(bit%64)) == 0 {
+			s.bits[bit/64] |= 1 << (bit % 64)
+			added = true
+		}
+	}
+	return added
+}
+
+func (s *bloomSet) Contains(key string) bool {
+	h1, h2 := s.hashes(key)
+	for i := uint64(0); i < s.k; i++ {
+		bit := (h1 + i*h2) % s.m
+		if s.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// robinHoodSet 是线性探测的开放寻址哈希表：插入时探测距离更短的 ("更富的") 条目让位给距离更长的，
+// 探测距离的方差小，查找不存在的键时遇到距离更短的条目即可停止
+type robinHoodSet struct {
+	slots []robinHoodSlot
+	mask  uint64
+	count int
+}
+
+// dist 为探测距离 + 1，0 表示空槽
+type robinHoodSlot struct {
+	key  string
+	hash uint64
+	dist uint32
+}
+
+func newRobinHoodSet(expected int) *robinHoodSet {
+	size := 16
+	for size*7/8 < expected {
+		size *= 2
+	}
+	return &robinHoodSet{slots: make([]robinHoodSlot, size), mask: uint64(size - 1)}
+}
+
+func (s *robinHoodSet) Add(key string) bool {
+	h := hashKey(key)
+	if s.contains(key, h) {
+		return false
+	}
+	// 装载率超过 7/8 时扩容
+	if (s.count+1)*8 > len(s.slots)*7 {
+		s.grow()
+	}
+	s.insert(robinHoodSlot{key: key, hash: h, dist: 1})
+	s.count++
+	return true
+}
+
+func (s *robinHoodSet) Contains(key string) bool {
+	return s.contains(key, hashKey(key))
+}
+
+func (s *robinHoodSet) contains(key string, h uint64) bool {
+	i := h & s.mask
+	for dist := uint32(1); ; dist++ {
+		slot := &s.slots[i]
+		if slot.dist < dist {
+			return false
+		}
+		if slot.hash == h && slot.key == key {
+			return true
+		}
+		i = (i + 1) & s.mask
+	}
+}
+
+func (s *robinHoodSet) insert(entry robinHoodSlot) {
+	i := entry.hash & s.mask
+	for {
+		slot := &s.slots[i]
+		if slot.dist == 0 {
+			*slot = entry
+			return
+		}
+		if slot.dist < entry.dist {
+			*slot, entry = entry, *slot
+		}
+		entry.dist++
+		i = (i + 1) & s.mask
+	}
+}
+
+func (s *robinHoodSet) grow() {
+	old := s.slots
+	s.slots = make([]robinHoodSlot, len(old)*2)
+	s.mask = uint64(len(s.slots) - 1)
+	for _, slot := range old {
+		if slot.dist != 0 {
+			slot.dist = 1
+			s.insert(slot)
+		}
+	}
+}
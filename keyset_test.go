@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeySets(t *testing.T) {
+	for _, algorithm := range []string{dedupAlgorithmMap, dedupAlgorithmBloom, dedupAlgorithmRobinHood} {
+		t.Run(algorithm, func(t *testing.T) {
+			// 预计键数远小于实际键数，robin-hood 需要多次扩容
+			set, err := newKeySet(algorithm, 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const n = 10000
+			for i := 0; i < n; i++ {
+				key := "session:" + strconv.Itoa(i)
+				if !set.Add(key) && algorithm != dedupAlgorithmBloom {
+					t.Fatalf("Add(%q) = false for a new key", key)
+				}
+				if set.Add(key) {
+					t.Fatalf("second Add(%q) = true, want false", key)
+				}
+			}
+			for i := 0; i < n; i++ {
+				if key := "session:" + strconv.Itoa(i); !set.Contains(key) {
+					t.Fatalf("Contains(%q) = false after Add", key)
+				}
+			}
+			if algorithm != dedupAlgorithmBloom {
+				for i := n; i < 2*n; i++ {
+					if key := "session:" + strconv.Itoa(i); set.Contains(key) {
+						t.Fatalf("Contains(%q) = true for a key never added", key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBloomSetFalsePositiveRate(t *testing.T) {
+	const n = 100000
+	set := newBloomSet(n, bloomFalsePositiveRate)
+	falseDups := 0
+	for i := 0; i < n; i++ {
+		if !set.Add("session:" + strconv.Itoa(i)) {
+			falseDups++
+		}
+	}
+	// 逐个加入时误判率随装载率上升，总体应低于满载时的设计误判率
+	if rate := float64(falseDups) / n; rate > bloomFalsePositiveRate {
+		t.Errorf("false duplicate rate = %.4f, want <= %.2f", rate, bloomFalsePositiveRate)
+	}
+
+	falsePositives := 0
+	for i := n; i < 2*n; i++ {
+		if set.Contains("session:" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 2*bloomFalsePositiveRate {
+		t.Errorf("false positive rate when full = %.4f, want about %.2f", rate, bloomFalsePositiveRate)
+	}
+}
+
+func TestNewKeySetUnknown(t *testing.T) {
+	if _, err := newKeySet("cuckoo", 10); err == nil {
+		t.Fatal("newKeySet(\"cuckoo\") succeeded, want error")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// KeyStatsFile 按键名保存每个键最近一次被处理的统计 (--key-stats-file)。
+// 与只追加的审计日志不同，同一个键只保留一条记录：清理期间的记录先缓存在内存中，
+// 每次清理结束时与文件中的记录合并，并删除早于 --key-stats-retention 的记录
+type KeyStatsFile struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	pending   map[string]*keyStatRecord // key|db -> 本次清理的记录
+}
+
+type keyStatRecord struct {
+	Key         string `json:"key"`
+	DB          int    `json:"db"`
+	Action      string `json:"action"`
+	Outcome     string `json:"outcome"`
+	LatencyMS   int64  `json:"latency_ms"`
+	KeyType     string `json:"key_type,omitempty"`
+	KeyEncoding string `json:"key_encoding,omitempty"`
+	MemoryBytes int64  `json:"memory_bytes,omitempty"`
+	EventTS     string `json:"event_ts,omitempty"`
+	ProcessedTS string `json:"processed_ts"`
+}
+
+// NewKeyStatsFile 创建写入 path 的 KeyStatsFile，retention 为 0 时不删除旧记录
+func NewKeyStatsFile(path string, retention time.Duration) *KeyStatsFile {
+	return &KeyStatsFile{path: path, retention: retention, pending: make(map[string]*keyStatRecord)}
+}
+
+func keyStatsIndex(key string, db int) string {
+	return key + "|" + strconv.Itoa(db)
+}
+
+// record 返回本次清理中 key 的记录，不存在时创建
+func (s *KeyStatsFile) record(key string, db int) *keyStatRecord {
+	index := keyStatsIndex(key, db)
+	rec, ok := s.pending[index]
+	if !ok {
+		rec = &keyStatRecord{Key: key, DB: db}
+		s.pending[index] = rec
+	}
+	return rec
+}
+
+// NoteEvent 记录过期事件的时间 (过期键文件中的 ts)，s 为 nil 时不做任何事
+func (s *KeyStatsFile) NoteEvent(key string, db int, ts string) {
+	if s == nil || ts == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record(key, db).EventTS = ts
+}
+
+// Inspect 在处理键之前读取键的类型、编码和内存占用，键已不存在时返回 nil。
+// s 为 nil 时不访问 Redis
+func (s *KeyStatsFile) Inspect(ctx context.Context, rdb RedisClient, key string) *keyStatRecord {
+	if s == nil {
+		return nil
+	}
+	// 键不存在时 OBJECT ENCODING 和 MEMORY USAGE 返回 nil，只看 TYPE 的结果
+	cmds, _ := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Type(ctx, key)
+		pipe.ObjectEncoding(ctx, key)
+		pipe.MemoryUsage(ctx, key)
+		return nil
+	})
+	if len(cmds) != 3 {
+		return nil
+	}
+	keyType := cmds[0].(*redis.StatusCmd).Val()
+	if keyType == "" || keyType == "none" {
+		return nil
+	}
+	return &keyStatRecord{
+		KeyType:     keyType,
+		KeyEncoding: cmds[1].(*redis.StringCmd).Val(),
+		MemoryBytes: cmds[2].(*redis.IntCmd).Val(),
+	}
+}
+
+// Record 记录键的处理结果，info 为 Inspect 的返回值。s 为 nil 时不做任何事
+func (s *KeyStatsFile) Record(key string, db int, action, outcome string, latency time.Duration, info *keyStatRecord) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.record(key, db)
+	rec.Action = action
+	rec.Outcome = outcome
+	rec.LatencyMS = latency.Milliseconds()
+	rec.ProcessedTS = time.Now().Format(time.RFC3339Nano)
+	if info != nil {
+		rec.KeyType = info.KeyType
+		rec.KeyEncoding = info.KeyEncoding
+		rec.MemoryBytes = info.MemoryBytes
+	}
+}
+
+// Merge 将本次清理的记录与文件中的记录合并后重写文件，同一个键以新记录为准，
+// 删除处理时间早于保留期限的记录。s 为 nil 时不做任何事
+func (s *KeyStatsFile) Merge() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	merged := make(map[string]*keyStatRecord, len(s.pending))
+	if err := s.load(merged); err != nil {
+		return err
+	}
+	for index, rec := range s.pending {
+		// 只有过期事件时间、没有处理结果的记录 (例如清理被中断) 不写入文件
+		if rec.ProcessedTS != "" {
+			merged[index] = rec
+		}
+	}
+	s.pending = make(map[string]*keyStatRecord)
+
+	var cutoff time.Time
+	if s.retention > 0 {
+		cutoff = time.Now().Add(-s.retention)
+	}
+	indexes := make([]string, 0, len(merged))
+	for index, rec := range merged {
+		processed, err := time.Parse(time.RFC3339Nano, rec.ProcessedTS)
+		if err != nil || processed.Before(cutoff) {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Strings(indexes)
+
+	// 先写入临时文件再重命名，避免写入过程中退出导致文件损坏
+	tmpPath := s.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, index := range indexes {
+		if err := encoder.Encode(merged[index]); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// 逐条读取文件中的记录，文件不存在时不返回错误
+func (s *KeyStatsFile) load(records map[string]*keyStatRecord) error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var rec keyStatRecord
+		err := decoder.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		records[keyStatsIndex(rec.Key, rec.DB)] = &rec
+	}
+}
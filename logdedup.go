@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// 开启 --suppress-duplicate-logs 时同一条消息两次输出之间的最短间隔，也是汇总被合并条数的周期
+const duplicateLogInterval = 30 * time.Second
+
+// 是否合并重复的日志，由 --suppress-duplicate-logs 设置
+var suppressDuplicateLogs bool
+
+// 每种 (消息格式, 键名前缀) 最近一次输出的时间和之后被合并的条数
+var lastLogTime sync.Map // map[duplicateLogKey]*duplicateLogState
+
+type duplicateLogKey struct {
+	format string
+	prefix string
+}
+
+type duplicateLogState struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+	message    string // 最近一条被合并的消息，汇总时输出
+}
+
+// deduplicateLog 输出逐键的日志。开启 --suppress-duplicate-logs 时，相同格式、相同键名前缀的消息
+// 只输出第一条，之后 30 秒内的相同消息只计数，由 flushDuplicateLogs 定期汇总输出，
+// 避免 Redis 不可达时批次中的每个键都输出一条相同的错误
+func deduplicateLog(prefix, format string, v ...interface{}) {
+	if !suppressDuplicateLogs {
+		log.Printf(format, v...)
+		return
+	}
+	value, _ := lastLogTime.LoadOrStore(duplicateLogKey{format: format, prefix: prefix}, &duplicateLogState{})
+	state := value.(*duplicateLogState)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	now := time.Now()
+	if !state.last.IsZero() && now.Sub(state.last) < duplicateLogInterval {
+		state.suppressed++
+		state.message = fmt.Sprintf(format, v...)
+		return
+	}
+	// 距上次输出已超过间隔时照常输出，顺便带上尚未汇总的条数
+	msg := fmt.Sprintf(format, v...)
+	if state.suppressed > 0 {
+		msg += fmt.Sprintf(" (Suppressed %d identical messages)", state.suppressed)
+	}
+	state.last = now
+	state.suppressed = 0
+	log.Print(msg)
+}
+
+// runDuplicateLogFlusher 每隔 duplicateLogInterval 输出一次被合并的条数，
+// 一批相同的错误结束后不再有新消息时计数也不会丢失，直到 ctx 被取消
+func runDuplicateLogFlusher(ctx context.Context) {
+	ticker := time.NewTicker(duplicateLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flushDuplicateLogs(time.Now())
+		case <-ctx.Done():
+			flushDuplicateLogs(time.Now())
+			return
+		}
+	}
+}
+
+// 输出所有有被合并消息的计数，并重新开始计时
+func flushDuplicateLogs(now time.Time) {
+	lastLogTime.Range(func(_, value interface{}) bool {
+		state := value.(*duplicateLogState)
+		state.mu.Lock()
+		if state.suppressed > 0 {
+			log.Printf("Suppressed %d identical messages, last: %s", state.suppressed, state.message)
+			state.suppressed = 0
+			state.last = now
+		}
+		state.mu.Unlock()
+		return true
+	})
+}
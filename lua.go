@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// 服务端原子地检查并删除已过期的键，避免 TTL 和 DEL 之间键被应用重新创建。
+// 键已过期时返回 1，否则返回 0
+const conditionalDeleteScript = `if redis.call('TTL', KEYS[1]) == -2 then
+	redis.call('DEL', KEYS[1])
+	return 1
+else
+	return 0
+end`
+
+// SCRIPT LOAD 得到的脚本 SHA，脚本被清除后重新加载时更新，并行分片时被多个 goroutine 读取
+var conditionalDeleteSHA atomic.Value // string
+
+// 加载条件删除脚本并缓存 SHA
+func loadConditionalDeleteScript(ctx context.Context, rdb RedisClient) error {
+	sha, err := rdb.ScriptLoad(ctx, conditionalDeleteScript).Result()
+	if err != nil {
+		return err
+	}
+	conditionalDeleteSHA.Store(sha)
+	return nil
+}
+
+// atomicConditionalDelete 通过 EVALSHA 在服务端执行条件删除，返回键是否已过期并被删除。
+// 脚本不在服务端缓存中 (Redis 重启或 SCRIPT FLUSH) 时重新 SCRIPT LOAD 一次后重试
+func atomicConditionalDelete(ctx context.Context, rdb RedisClient, key string) (bool, error) {
+	sha, _ := conditionalDeleteSHA.Load().(string)
+	n, err := rdb.EvalSha(ctx, sha, []string{key}).Int64()
+	if isNoScript(err) {
+		log.Println("Conditional delete script is no longer cached by Redis, loading it again")
+		if err := loadConditionalDeleteScript(ctx, rdb); err != nil {
+			return false, err
+		}
+		sha, _ = conditionalDeleteSHA.Load().(string)
+		n, err = rdb.EvalSha(ctx, sha, []string{key}).Int64()
+	}
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// 脚本不在服务端缓存中 (例如 Redis 重启或执行了 SCRIPT FLUSH)
+func isNoScript(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
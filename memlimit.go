@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// 检查堆内存占用的间隔和告警阈值 (占 --memory-limit-mb 的比例)
+const (
+	memoryCheckInterval = 10 * time.Second
+	memoryWarnRatio     = 0.8
+)
+
+// 设置 Go 运行时的软内存上限 (--memory-limit-mb)，接近上限时 GC 更频繁地运行。
+// 这不能防止 OOM：实际使用的内存仍然可能超过上限
+func setMemoryLimit(limitMB int64) int64 {
+	limit := limitMB * 1024 * 1024
+	debug.SetMemoryLimit(limit)
+	log.Printf("Set Go memory limit to %d MiB", limitMB)
+	return limit
+}
+
+// 定期输出当前堆内存占用的指标，超过上限的 80% 时打印告警，ctx 取消时返回
+func monitorMemory(ctx context.Context, limit int64, metrics *Metrics) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+
+	var stats runtime.MemStats
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		runtime.ReadMemStats(&stats)
+		metrics.Gauge(metricHeapBytes, float64(stats.HeapAlloc), nil)
+		if float64(stats.HeapAlloc) > float64(limit)*memoryWarnRatio {
+			log.Printf("WARN: Heap usage %d MiB exceeds %.0f%% of --memory-limit-mb %d MiB",
+				stats.HeapAlloc>>20, memoryWarnRatio*100, limit>>20)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 指标名称，各个后端使用相同的名称
+const (
+	metricKeysReceived    = "redis_expire_keys_received_total"
+	metricKeysProcessed   = "redis_expire_keys_processed_total"
+	metricCleanupDuration = "redis_expire_cleanup_duration_seconds"
+	metricKeysByEncoding  = "redis_expire_keys_by_encoding_total"
+	metricKeyEvents       = "redis_expire_keyevents_total"
+
+	metricVerifyStillPresent = "redis_expire_keys_verify_still_present"
+	metricPrefixRate         = "redis_expire_prefix_rate"
+	metricKeyTTL             = "redis_expire_key_ttl_seconds"
+	metricKeysError          = "redis_expire_keys_error_total"
+	metricKeysTooOld         = "redis_expire_keys_too_old_skipped"
+	metricEventsRateLimited  = "redis_expire_events_rate_limited_total"
+	metricKeyTTLBucket       = "redis_expire_key_ttl_bucket_seconds"
+	metricPubsubBuffer       = "redis_expire_pubsub_buffer_fullness"
+	metricCleanupErrors      = "redis_expire_cleanup_errors_total"
+	metricHeapBytes          = "redis_expire_heap_bytes_current"
+	metricHealthCheckFailed  = "redis_expire_subscription_health_checks_failed_total"
+	metricUniqueKeysPerRun   = "redis_expire_keys_unique_per_run"
+)
+
+// 指标说明，用作 Prometheus 的 HELP
+var metricHelp = map[string]string{
+	metricKeysReceived:    "Number of expired key events received.",
+	metricKeysProcessed:   "Number of keys processed by cleanup, by outcome.",
+	metricCleanupDuration: "Duration of cleanup runs in seconds.",
+	metricKeysByEncoding:  "Number of processed keys by OBJECT ENCODING.",
+	metricKeyEvents:       "Number of non-expiry keyspace events received, by event.",
+
+	metricVerifyStillPresent: "Number of keys that still existed when verified after deletion.",
+	metricPrefixRate:         "Expiry events per second by key prefix since the previous report.",
+	metricKeyTTL:             "Actual lifetime in seconds of expired keys that have a TTL hint.",
+	metricKeysError:          "Number of keys that failed during cleanup with --ignore-errors, by error category.",
+	metricKeysTooOld:         "Number of keys skipped by cleanup because they expired longer ago than --key-age-threshold.",
+	metricEventsRateLimited:  "Number of expiry events dropped by --max-events-per-second.",
+	metricKeyTTLBucket:       "TTL of expired keys from the TTL hint, or the interval between two expiries of the same key name.",
+	metricPubsubBuffer:       "Ratio of queued pubsub messages to the --key-events-buffer-size capacity.",
+	metricCleanupErrors:      "Number of failed cleanup runs.",
+	metricHeapBytes:          "Bytes of allocated heap objects, sampled every 10s with --memory-limit-mb.",
+	metricHealthCheckFailed:  "Number of --pubsub-health-check-interval checks whose canary expiry event did not arrive.",
+	metricUniqueKeysPerRun:   "Number of unique keys read by each cleanup run after deduplication.",
+}
+
+// 直方图的分桶，未登记的指标使用后端的默认分桶
+var metricBuckets = map[string][]float64{
+	metricKeyTTL:           {1, 5, 10, 30, 60, 300, 600, 1800, 3600, 7200, 21600, 43200, 86400, 604800},
+	metricUniqueKeysPerRun: {1, 10, 100, 1000, 10000, 100000, 1000000},
+}
+
+// MetricBackend 是指标的导出后端，同一个指标每次传入的标签名必须一致
+type MetricBackend interface {
+	Count(name string, value int64, labels map[string]string)
+	Gauge(name string, value float64, labels map[string]string)
+	Observe(name string, value float64, labels map[string]string) // 直方图 / 分布
+}
+
+// Metrics 将指标同时发送到所有已启用的后端，没有后端时不做任何事
+type Metrics struct {
+	backends []MetricBackend
+}
+
+// NewMetrics 创建发送到 backends 的 Metrics
+func NewMetrics(backends ...MetricBackend) *Metrics {
+	return &Metrics{backends: backends}
+}
+
+func (m *Metrics) Count(name string, value int64, labels map[string]string) {
+	if m == nil {
+		return
+	}
+	for _, b := range m.backends {
+		b.Count(name, value, labels)
+	}
+}
+
+func (m *Metrics) Gauge(name string, value float64, labels map[string]string) {
+	if m == nil {
+		return
+	}
+	for _, b := range m.backends {
+		b.Gauge(name, value, labels)
+	}
+}
+
+func (m *Metrics) Observe(name string, value float64, labels map[string]string) {
+	if m == nil {
+		return
+	}
+	for _, b := range m.backends {
+		b.Observe(name, value, labels)
+	}
+}
+
+// 记录一个键的编码
+func (m *Metrics) observeEncoding(encoding string) {
+	m.Count(metricKeysByEncoding, 1, map[string]string{"encoding": encoding})
+}
+
+// 按名称排序的标签名
+func labelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 在 addr 上启动 HTTP 服务，通过 /metrics 导出指标，/ws/keys 和 /events 实时推送过期事件
+func serveHTTP(addr string, broker *eventBroker, allowedOrigins []string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/ws/keys", wsKeysHandler(broker, allowedOrigins))
+	mux.Handle("/events", sseEventsHandler(broker))
+
+	go func() {
+		log.Printf("Serving metrics on %s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// prometheusBackend 在第一次使用某个指标时创建并注册对应的向量
+type prometheusBackend struct {
+	reg prometheus.Registerer
+
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+func newPrometheusBackend(reg prometheus.Registerer) *prometheusBackend {
+	return &prometheusBackend{
+		reg:        reg,
+		counters:   make(map[string]*prometheus.CounterVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+	}
+}
+
+func (b *prometheusBackend) Count(name string, value int64, labels map[string]string) {
+	b.mu.Lock()
+	vec, ok := b.counters[name]
+	if !ok {
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help(name)}, labelNames(labels))
+		b.reg.MustRegister(vec)
+		b.counters[name] = vec
+	}
+	b.mu.Unlock()
+	vec.With(labels).Add(float64(value))
+}
+
+func (b *prometheusBackend) Gauge(name string, value float64, labels map[string]string) {
+	b.mu.Lock()
+	vec, ok := b.gauges[name]
+	if !ok {
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help(name)}, labelNames(labels))
+		b.reg.MustRegister(vec)
+		b.gauges[name] = vec
+	}
+	b.mu.Unlock()
+	vec.With(labels).Set(value)
+}
+
+func (b *prometheusBackend) Observe(name string, value float64, labels map[string]string) {
+	b.mu.Lock()
+	vec, ok := b.histograms[name]
+	if !ok {
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help(name), Buckets: metricBuckets[name]}, labelNames(labels))
+		b.reg.MustRegister(vec)
+		b.histograms[name] = vec
+	}
+	b.mu.Unlock()
+	vec.With(labels).Observe(value)
+}
+
+// 指标说明，未登记的指标使用名称本身
+func help(name string) string {
+	if h, ok := metricHelp[name]; ok {
+		return h
+	}
+	return name
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+)
+
+// statsdBackend 将指标以 StatsD (DogStatsD) 格式发送，标签转换为 name:value 形式的 tag
+type statsdBackend struct {
+	client *statsd.Client
+}
+
+// constTags 作为全局 tag 附加到每个指标上
+func newStatsdBackend(addr, prefix string, constTags map[string]string) (*statsdBackend, error) {
+	client, err := statsd.New(addr, statsd.WithNamespace(prefix), statsd.WithTags(tags(constTags)))
+	if err != nil {
+		return nil, err
+	}
+	return &statsdBackend{client: client}, nil
+}
+
+func (b *statsdBackend) Count(name string, value int64, labels map[string]string) {
+	b.report(b.client.Count(name, value, tags(labels), 1))
+}
+
+func (b *statsdBackend) Gauge(name string, value float64, labels map[string]string) {
+	b.report(b.client.Gauge(name, value, tags(labels), 1))
+}
+
+func (b *statsdBackend) Observe(name string, value float64, labels map[string]string) {
+	b.report(b.client.Distribution(name, value, tags(labels), 1))
+}
+
+func (b *statsdBackend) report(err error) {
+	if err != nil {
+		debugf("Failed to send StatsD metric: %v", err)
+	}
+}
+
+// 将标签转换为 StatsD tag
+func tags(labels map[string]string) []string {
+	result := make([]string, 0, len(labels))
+	for _, name := range labelNames(labels) {
+		result = append(result, name+":"+labels[name])
+	}
+	return result
+}
+
+// 关闭前发送缓冲中的指标
+func (b *statsdBackend) Close() {
+	if err := b.client.Close(); err != nil {
+		log.Printf("Failed to close StatsD client: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// dry-run 时最多输出的行数
+const migrateDryRunLines = 100
+
+// migrate-file 子命令：将纯文本格式的过期键文件转换为 JSON 格式 (每行一个 KeyRecord)。
+// 旧文件没有事件时间，使用文件的修改时间作为近似值
+//
+//	RedisExpireKeysDelete migrate-file --input=.expired_keys --output=.expired_keys.jsonl --db=0
+func runMigrateFile(args []string) error {
+	fs := flag.NewFlagSet("migrate-file", flag.ExitOnError)
+	input := fs.String("input", "", "Plain-text key file to convert")
+	output := fs.String("output", "", "JSON-lines file to write")
+	db := fs.Int("db", 0, "Database number recorded in each converted record")
+	dryRun := fs.Bool("dry-run", false, fmt.Sprintf("Print the first %d converted lines instead of writing --output", migrateDryRunLines))
+	fs.Parse(args)
+
+	if *input == "" || (*output == "" && !*dryRun) {
+		return fmt.Errorf("--input and --output are required")
+	}
+
+	in, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	ts := info.ModTime().Format(time.RFC3339Nano)
+
+	var out io.Writer = os.Stdout
+	if !*dryRun {
+		file, err := os.OpenFile(*output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+
+	converted := 0
+	scanner := bufio.NewScanner(in)
+	growScanBuffer(scanner)
+	for scanner.Scan() {
+		rec, ok := parseKeyLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		// 已经是 JSON 格式的行保留原有字段
+		if rec.TS == "" {
+			rec.DB = *db
+			rec.TS = ts
+		}
+
+		line, err := encodeKeyRecord(rec, formatJSON)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return err
+		}
+		converted++
+		if *dryRun && converted >= migrateDryRunLines {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	if !*dryRun {
+		log.Printf("Converted %d keys from %s to %s", converted, *input, *output)
+	}
+	return nil
+}
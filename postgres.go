@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// 过期键的存储位置 (--key-store-backend)
+const (
+	storeBackendFile     = "file"
+	storeBackendPostgres = "postgres"
+)
+
+// 写入 PostgreSQL 时每批最多的记录数和最长的等待时间
+const (
+	postgresBatchSize     = 500
+	postgresFlushInterval = time.Second
+)
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS expired_keys (
+	id BIGSERIAL PRIMARY KEY,
+	key TEXT NOT NULL,
+	db INT NOT NULL,
+	event_time TIMESTAMPTZ NOT NULL,
+	processed_at TIMESTAMPTZ,
+	is_processed BOOLEAN DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS expired_keys_pending ON expired_keys (db, id) WHERE NOT is_processed`
+
+// PostgresKeyStore 将过期键写入 PostgreSQL 的 expired_keys 表 (--key-store-backend=postgres)，
+// 代替过期键文件。清理时读取未处理的记录，处理完成后标记 is_processed，
+// 中断时未标记的记录留给下一次清理，不需要备份文件
+type PostgresKeyStore struct {
+	pool *pgxpool.Pool
+
+	// 等待批量写入的记录，达到 postgresBatchSize 或每隔 postgresFlushInterval 用一个 pgx.Batch 写入。
+	// 进程异常退出时最多丢失最近一秒的记录
+	mu      sync.Mutex
+	pending []KeyRecord
+}
+
+// NewPostgresKeyStore 连接 dsn 指定的数据库，表不存在时创建
+func NewPostgresKeyStore(ctx context.Context, dsn string) (*PostgresKeyStore, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pool.Exec(ctx, postgresSchema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to create expired_keys table: %v", err)
+	}
+	return &PostgresKeyStore{pool: pool}, nil
+}
+
+// Append 将记录加入下一批写入，批次已满时立即写入
+func (s *PostgresKeyStore) Append(rec KeyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, rec)
+	if len(s.pending) < postgresBatchSize {
+		return nil
+	}
+	return s.flush(context.Background())
+}
+
+// Run 每隔 postgresFlushInterval 写入等待中的记录，直到 ctx 被取消
+func (s *PostgresKeyStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(postgresFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		if err := s.flush(ctx); err != nil {
+			log.Printf("Failed to write expired keys to PostgreSQL, retrying: %v", err)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close 写入剩余的记录并关闭连接池
+func (s *PostgresKeyStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.flush(ctx); err != nil {
+		log.Printf("Failed to write %d expired keys to PostgreSQL on shutdown: %v", len(s.pending), err)
+	}
+	s.pool.Close()
+}
+
+// 用一个 pgx.Batch 写入等待中的记录，失败时保留记录等待下一次重试。调用方持有 s.mu
+func (s *PostgresKeyStore) flush(ctx context.Context) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	batch := &pgx.Batch{}
+	for _, rec := range s.pending {
+		eventTime, err := time.Parse(time.RFC3339Nano, rec.TS)
+		if err != nil {
+			eventTime = time.Now()
+		}
+		batch.Queue("INSERT INTO expired_keys (key, db, event_time) VALUES ($1, $2, $3)", rec.Key, rec.DB, eventTime)
+	}
+	results := s.pool.SendBatch(ctx, batch)
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			results.Close()
+			return err
+		}
+	}
+	if err := results.Close(); err != nil {
+		return err
+	}
+	s.pending = s.pending[:0]
+	return nil
+}
+
+// Pending 按写入顺序返回 db 中未处理的键 (已去重) 以及每个键对应的记录 id
+func (s *PostgresKeyStore) Pending(ctx context.Context, db int) ([]string, map[string][]int64, error) {
+	rows, err := s.pool.Query(ctx, "SELECT id, key FROM expired_keys WHERE db = $1 AND NOT is_processed ORDER BY id", db)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	ids := make(map[string][]int64)
+	for rows.Next() {
+		var id int64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := ids[key]; !ok {
+			keys = append(keys, key)
+		}
+		ids[key] = append(ids[key], id)
+	}
+	return keys, ids, rows.Err()
+}
+
+// MarkProcessed 将 ids 对应的记录标记为已处理
+func (s *PostgresKeyStore) MarkProcessed(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.pool.Exec(ctx, "UPDATE expired_keys SET is_processed = TRUE, processed_at = now() WHERE id = ANY($1)", ids)
+	return err
+}
+
+// 从 PostgreSQL 读取未处理的键并依次处理，处理完的键标记为已处理。
+// 中断时未处理的键保持未处理状态，不需要像文件那样写回
+func (c *Cleaner) lazyDeleteFromPostgres(ctx context.Context, strategy DeletionStrategy) error {
+	db := c.rdb.Options().DB
+	keys, ids, err := c.pg.Pending(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed to read expired keys from PostgreSQL: %v", err)
+	}
+	if len(keys) == 0 {
+		debugf("No unprocessed expired keys for db %d in PostgreSQL, skipping lazy deletion", db)
+		return nil
+	}
+
+	log.Println("Start lazily deleting")
+	c.metrics.Observe(metricUniqueKeysPerRun, float64(len(keys)), nil)
+	stats := c.lastStats
+	defer func() {
+		log.Printf("Lazy deletion summary: %v", stats)
+		c.metrics.Observe(metricCleanupDuration, time.Since(stats.start).Seconds(), nil)
+	}()
+
+	processed, err := c.processKeys(ctx, keys, db, strategy, stats)
+	var done []int64
+	for _, key := range keys[:processed] {
+		done = append(done, ids[key]...)
+	}
+	// ctx 可能已经取消，标记使用单独的 ctx，否则已处理的键下次会被重复处理
+	markCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.pg.MarkProcessed(markCtx, done); err != nil {
+		return fmt.Errorf("failed to mark processed keys in PostgreSQL: %v", err)
+	}
+	if processed < len(keys) {
+		log.Printf("Lazy deletion interrupted (%v): processed %d/%d keys, %d keys left for the next cleanup",
+			err, processed, len(keys), len(keys)-processed)
+	}
+	if _, ok := err.(*keyError); ok {
+		return err
+	}
+	return nil
+}
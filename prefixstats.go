@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 报告中最多列出的前缀数量，同时限制 redis_expire_prefix_rate 的标签基数
+const prefixStatsTopN = 20
+
+// prefixStats 按键名前缀 (第一个 --namespace-separator 之前的部分) 统计过期事件，
+// 每次清理结束或每隔 --stats-interval 输出一张表并更新 redis_expire_prefix_rate
+type prefixStats struct {
+	separator string
+	metrics   *Metrics
+	counts    sync.Map // 前缀 -> *atomic.Int64
+
+	mu       sync.Mutex
+	since    time.Time
+	reported map[string]bool // 上一次报告过的前缀，本次没有事件时将其指标置 0
+}
+
+func newPrefixStats(separator string, metrics *Metrics) *prefixStats {
+	return &prefixStats{separator: separator, metrics: metrics, since: time.Now(), reported: make(map[string]bool)}
+}
+
+// Observe 记录一次过期事件，s 为 nil 时不统计
+func (s *prefixStats) Observe(key string) {
+	if s == nil {
+		return
+	}
+	prefix := keyPrefix(key, s.separator)
+	v, ok := s.counts.Load(prefix)
+	if !ok {
+		v, _ = s.counts.LoadOrStore(prefix, new(atomic.Int64))
+	}
+	v.(*atomic.Int64).Add(1)
+}
+
+// Run 每隔 interval 输出一次报告，直到 ctx 结束
+func (s *prefixStats) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Report()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Report 输出自上次报告以来各前缀的过期数量和占比，并重置计数
+func (s *prefixStats) Report() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []prefixCount
+	var total int64
+	s.counts.Range(func(k, v interface{}) bool {
+		s.counts.Delete(k)
+		n := v.(*atomic.Int64).Load()
+		rows = append(rows, prefixCount{prefix: k.(string), count: n})
+		total += n
+		return true
+	})
+	elapsed := time.Since(s.since).Seconds()
+	s.since = time.Now()
+	if total == 0 {
+		return
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].count > rows[j].count })
+	if len(rows) > prefixStatsTopN {
+		rows = rows[:prefixStatsTopN]
+	}
+
+	var b strings.Builder
+	b.WriteString("prefix | count | percent_of_total")
+	current := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		fmt.Fprintf(&b, "\n%s | %d | %.1f%%", row.prefix, row.count, float64(row.count)*100/float64(total))
+		s.metrics.Gauge(metricPrefixRate, float64(row.count)/elapsed, map[string]string{"prefix": row.prefix})
+		current[row.prefix] = true
+	}
+	for prefix := range s.reported {
+		if !current[prefix] {
+			s.metrics.Gauge(metricPrefixRate, 0, map[string]string{"prefix": prefix})
+		}
+	}
+	s.reported = current
+
+	log.Printf("Expired keys by prefix (%d events):\n%s", total, b.String())
+}
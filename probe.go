@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// --test-expiry 使用的探测键
+const expiryProbeKey = "__expiry_probe__:test"
+
+// 写入一个 100ms 后过期的探测键，等待最多 2 秒收到它的过期事件，
+// 用于端到端验证订阅和 notify-keyspace-events 配置是否生效
+func verifySubscription(ctx context.Context, rdb *redis.Client, received <-chan struct{}) {
+	if err := rdb.Set(ctx, expiryProbeKey, 1, 100*time.Millisecond).Err(); err != nil {
+		log.Printf("WARN: Failed to set expiry probe key: %v", err)
+		return
+	}
+
+	select {
+	case <-received:
+		log.Println("Subscription verified: received expiry event for probe key")
+	case <-time.After(2 * time.Second):
+		log.Printf("WARN: No expiry event received for probe key %s within 2s, check notify-keyspace-events", expiryProbeKey)
+	case <-ctx.Done():
+	}
+}
+
+// --pubsub-health-check-interval 使用的金丝雀键前缀、过期时间和等待事件的超时
+const (
+	healthCheckPrefix  = "__health_check__:"
+	healthCheckTTL     = 2 * time.Second
+	healthCheckTimeout = 5 * time.Second
+)
+
+// 每隔 interval 写入一个 2 秒后过期的金丝雀键，5 秒内没有收到它的过期事件时，
+// 认为订阅已经静默失效 (例如代理超时或网络分区)，调用 resubscribe 重新订阅
+func checkSubscriptionHealth(ctx context.Context, rdb *redis.Client, interval time.Duration, received <-chan string, resubscribe func(), metrics *Metrics) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		key := fmt.Sprintf("%s%d", healthCheckPrefix, time.Now().UnixNano())
+		if err := rdb.Set(ctx, key, 1, healthCheckTTL).Err(); err != nil {
+			// Redis 不可用由 --connection-test-interval 和事件收集的重连处理
+			log.Printf("WARN: Failed to set subscription health check key: %v", err)
+			continue
+		}
+		if waitForCanary(ctx, rdb, key, received) || ctx.Err() != nil {
+			continue
+		}
+		metrics.Count(metricHealthCheckFailed, 1, nil)
+		log.Printf("WARN: No expiry event for health check key %s within %v, subscription looks stale, resubscribing", key, healthCheckTimeout)
+		resubscribe()
+	}
+}
+
+// 等待 key 的过期事件，其他 (上一次检查迟到的) 金丝雀键忽略。
+// Redis 的主动过期是抽样进行的，过期后访问一次键触发惰性过期，避免在键很多的库中误判
+func waitForCanary(ctx context.Context, rdb *redis.Client, key string, received <-chan string) bool {
+	timeout := time.After(healthCheckTimeout)
+	touch := time.After(healthCheckTTL + 500*time.Millisecond)
+	for {
+		select {
+		case got := <-received:
+			if got == key {
+				return true
+			}
+		case <-touch:
+			rdb.Exists(ctx, key)
+		case <-timeout:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// 过期键文件格式
+const (
+	formatText      = "text"       // 每行一个键名
+	formatJSON      = "json"       // 每行一个 JSON 格式的 KeyRecord
+	formatBinaryLog = "binary-log" // 每条记录为 4 字节小端长度 + 键名，可以检测出不完整的记录
+)
+
+// binary-log 格式单条记录的最大长度，超出说明长度前缀已损坏
+const maxBinaryRecordLen = 512 << 20
+
+// 读取记录时 Scanner 的初始缓冲大小，遇到更长的记录时按需增长
+const recordScanBuffer = 64 << 10
+
+// 读取时单条记录的上限。bufio.Scanner 默认只接受 64 KiB 以内的 token，
+// 而键名最长可达 --max-key-len (为 0 时不限制)，binary-log 记录最长为 maxBinaryRecordLen，
+// 另外留出长度前缀和 JSON 其他字段的余量
+func maxRecordLen() int {
+	n := maxBinaryRecordLen
+	if maxKeyLen > n {
+		n = maxKeyLen
+	}
+	return n + recordScanBuffer
+}
+
+// 让 scanner 可以读取最长 maxRecordLen 的记录
+func growScanBuffer(scanner *bufio.Scanner) {
+	scanner.Buffer(make([]byte, 0, recordScanBuffer), maxRecordLen())
+}
+
+// KeyRecord 是 JSON 格式过期键文件中的一条记录
+type KeyRecord struct {
+	Key  string            `json:"key"`
+	DB   int               `json:"db"`
+	TS   string            `json:"ts"`
+	Meta map[string]string `json:"meta,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// 以下字段仅在 --capture-expiry-time 时记录
+	ExpireMethod string `json:"expire_method,omitempty"` // keyevent 或 scan
+	ExpireTime   int64  `json:"expire_time,omitempty"`   // EXPIRETIME 返回的 Unix 时间戳 (秒)
+}
+
+// 将记录编码为文件中的一行 (不含换行符)，binary-log 格式只保存键名
+func encodeKeyRecord(rec KeyRecord, format string) (string, error) {
+	if format != formatJSON {
+		return rec.Key, nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// 为编码后的记录加上分隔：binary-log 格式加长度前缀，其他格式追加换行符
+func frameRecord(line, format string) []byte {
+	if format != formatBinaryLog {
+		return []byte(line + "\n")
+	}
+	buf := make([]byte, 4+len(line))
+	binary.LittleEndian.PutUint32(buf, uint32(len(line)))
+	copy(buf[4:], line)
+	return buf
+}
+
+// newRecordScanner 返回按 format 逐条读取记录的 Scanner，每个 token 交给 parseRecord 解析。
+// 开启 --key-file-encrypt 时先逐块解密
+func newRecordScanner(r io.Reader, format string) *bufio.Scanner {
+	return newFrameScanner(decryptReader(r), format)
+}
+
+// newFrameScanner 与 newRecordScanner 相同，但 r 已经是明文 (解密后的备份文件或内存中的记录)
+func newFrameScanner(r io.Reader, format string) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	growScanBuffer(scanner)
+	if format == formatBinaryLog {
+		scanner.Split(splitBinaryLog)
+	}
+	return scanner
+}
+
+// splitBinaryLog 是 binary-log 格式的 bufio.SplitFunc。
+// 文件末尾不完整的记录 (例如写入进程异常退出) 会被跳过
+func splitBinaryLog(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) >= 4 {
+		n := binary.LittleEndian.Uint32(data)
+		if n > maxBinaryRecordLen {
+			return 0, nil, fmt.Errorf("corrupt binary-log record length %d", n)
+		}
+		if len(data) >= 4+int(n) {
+			return 4 + int(n), data[4 : 4+n], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		log.Printf("WARN: Skipping partial binary-log record at end of file (%d bytes)", len(data))
+		return len(data), nil, nil
+	}
+	return 0, nil, nil
+}
+
+// 解析 newRecordScanner 读出的一条记录
+func parseRecord(token, format string) (KeyRecord, bool) {
+	if format == formatBinaryLog {
+		return KeyRecord{Key: token}, token != ""
+	}
+	return parseKeyLine(token)
+}
+
+// 解析文件中的一行，自动识别纯文本和 JSON 格式
+func parseKeyLine(line string) (KeyRecord, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return KeyRecord{}, false
+	}
+	if strings.HasPrefix(line, "{") {
+		var rec KeyRecord
+		if err := json.Unmarshal([]byte(line), &rec); err == nil && rec.Key != "" {
+			return rec, true
+		}
+	}
+	return KeyRecord{Key: line}, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 超过 bufio.Scanner 默认 64 KiB 上限的记录在各种格式下都能读回
+func TestLongRecords(t *testing.T) {
+	long := "session:" + strings.Repeat("x", 100<<10)
+	for _, format := range []string{formatText, formatJSON, formatBinaryLog} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			for _, key := range []string{"a", long, "b"} {
+				line, err := encodeKeyRecord(KeyRecord{Key: key}, format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				buf.Write(frameRecord(line, format))
+			}
+			var got []string
+			scanner := newFrameScanner(&buf, format)
+			for scanner.Scan() {
+				if rec, ok := parseRecord(scanner.Text(), format); ok {
+					got = append(got, rec.Key)
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			if len(got) != 3 || got[0] != "a" || got[1] != long || got[2] != "b" {
+				t.Errorf("read %d records, want a, the %d-byte key and b", len(got), len(long))
+			}
+
+			// 过期键文件重建索引时同样能读到长记录
+			store := NewFileKeyStore(filepath.Join(t.TempDir(), "expired_keys.txt"), format)
+			if err := store.Append(KeyRecord{Key: long}); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			if err := store.eachRecord(func(rec KeyRecord, _ string) { keys = append(keys, rec.Key) }); err != nil {
+				t.Fatalf("eachRecord: %v", err)
+			}
+			if len(keys) != 1 || keys[0] != long {
+				t.Errorf("key file has %d records, want the long key", len(keys))
+			}
+		})
+	}
+}
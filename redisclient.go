@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 连续多少次 PING 失败后触发重新连接
+const maxPingFailures = 3
+
+// BackoffPolicy 计算重新连接的等待时间：min(Initial * 2^attempt, Max) + jitter，
+// jitter 为 [0, 10%) 的随机值，避免多个实例同时重连。连接成功后调用 Reset
+type BackoffPolicy struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	attempt int
+}
+
+// NextDelay 返回下一次重试前的等待时间，并增加重试次数
+func (b *BackoffPolicy) NextDelay() time.Duration {
+	delay := b.Initial
+	for i := 0; i < b.attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	// delay 达到上限后不再增加 attempt，避免一直失败时溢出
+	if delay < b.Max {
+		b.attempt++
+	}
+	return delay + randomJitter(delay/10)
+}
+
+// Reset 在连接成功后重新从 Initial 开始
+func (b *BackoffPolicy) Reset() {
+	b.attempt = 0
+}
+
+// RedisClient 是本工具用到的 Redis 命令子集，*redis.Client 实现了该接口，
+// 单元测试中可以使用 internal/testutil.FakeRedisClient 代替
+type RedisClient interface {
+	Options() *redis.Options
+	Ping(ctx context.Context) *redis.StatusCmd
+	Type(ctx context.Context, key string) *redis.StatusCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	Unlink(ctx context.Context, keys ...string) *redis.IntCmd
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd
+	ObjectEncoding(ctx context.Context, key string) *redis.StringCmd
+	ObjectRefCount(ctx context.Context, key string) *redis.IntCmd
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+	Pipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
+	ScriptLoad(ctx context.Context, script string) *redis.StringCmd
+	EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd
+	TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
+}
+
+// parseConnectionString 解析 --connection-string 并覆盖 cfg 中的 --addr、--password、--db 和 --acl-username，
+// 其他地方 (频道名、按数据库分文件等) 照常使用这些字段。rediss:// 时返回的 Options 带有 TLSConfig
+func parseConnectionString(cfg *Config) (*redis.Options, error) {
+	opts, err := redis.ParseURL(cfg.ConnectionString)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Addr == "" {
+		return nil, fmt.Errorf("missing host in %s", opts.Network)
+	}
+	cfg.Addr = opts.Addr
+	cfg.Password = opts.Password
+	cfg.DB = opts.DB
+	cfg.ACLUsername = opts.Username
+	return opts, nil
+}
+
+// Redis 默认配置的数据库数量 (databases 16)
+const defaultDBCount = 16
+
+// detectDBCount 通过 CONFIG GET databases 获取 Redis 配置的数据库数量，
+// 托管服务禁用了 CONFIG 命令时使用 fallback (--redis-db-count)
+func detectDBCount(ctx context.Context, rdb *redis.Client, fallback int) int {
+	values, err := rdb.ConfigGet(ctx, "databases").Result()
+	if err == nil && len(values) == 2 {
+		if s, ok := values[1].(string); ok {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 {
+				debugf("Redis is configured with %d databases", n)
+				return n
+			}
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected reply %v", values)
+	}
+	log.Printf("WARN: Failed to get the number of databases from CONFIG GET databases, using --redis-db-count=%d: %v", fallback, err)
+	return fallback
+}
+
+// waitForRedis 在启动时等待 Redis 可用，最多尝试 retries 次，两次尝试之间按 backoff 等待
+func waitForRedis(ctx context.Context, rdb RedisClient, retries int, backoff *BackoffPolicy) error {
+	var err error
+	for attempt := 1; attempt <= retries; attempt++ {
+		if err = rdb.Ping(ctx).Err(); err == nil {
+			return nil
+		}
+		if attempt == retries {
+			break
+		}
+
+		delay := backoff.NextDelay()
+		log.Printf("WARN: Redis is not available (attempt %d/%d): %v, retrying in %v", attempt, retries, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("redis is not available after %d attempts: %v", retries, err)
+}
+
+// 每隔 interval PING 一次 Redis，连续 3 次失败时调用 onFailure (可以为 nil)，直到 ctx 被取消
+func testConnection(ctx context.Context, rdb RedisClient, interval time.Duration, onFailure func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Printf("WARN: Redis ping failed (%d/%d): %v", failures, maxPingFailures, err)
+			if failures >= maxPingFailures {
+				if onFailure != nil {
+					log.Println("WARN: Redis connection looks dead, reconnecting")
+					onFailure()
+				}
+				failures = 0
+			}
+			continue
+		}
+		failures = 0
+	}
+}
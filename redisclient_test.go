@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RESIDUALWASTE/RedisExpireKeysDelete/internal/testutil"
+)
+
+// 判断 got 是否为 want 加上 [0, want/10) 的 jitter
+func withJitter(got, want time.Duration) bool {
+	return got >= want && got < want+want/10
+}
+
+func TestBackoffPolicyNextDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial time.Duration
+		max     time.Duration
+		want    []time.Duration
+	}{
+		{
+			name:    "doubles until max",
+			initial: time.Second,
+			max:     10 * time.Second,
+			want:    []time.Duration{1, 2, 4, 8, 10, 10, 10},
+		},
+		{
+			name:    "initial equals max",
+			initial: 5 * time.Second,
+			max:     5 * time.Second,
+			want:    []time.Duration{5, 5, 5},
+		},
+		{
+			name:    "initial above max",
+			initial: 30 * time.Second,
+			max:     10 * time.Second,
+			want:    []time.Duration{10, 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &BackoffPolicy{Initial: tt.initial, Max: tt.max}
+			for i, want := range tt.want {
+				want *= time.Second
+				got := b.NextDelay()
+				if !withJitter(got, want) {
+					t.Fatalf("delay %d = %v, want %v plus jitter", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffPolicyNeverExceedsMax(t *testing.T) {
+	b := &BackoffPolicy{Initial: time.Millisecond, Max: time.Minute}
+	// 足够多次调用，确认达到上限后不会因为溢出变成负数或 0
+	for i := 0; i < 1000; i++ {
+		delay := b.NextDelay()
+		if delay <= 0 || delay >= time.Minute+time.Minute/10 {
+			t.Fatalf("delay %d = %v, want in (0, %v)", i, delay, time.Minute+time.Minute/10)
+		}
+	}
+}
+
+func TestBackoffPolicyJitter(t *testing.T) {
+	const base = 100 * time.Second
+	b := &BackoffPolicy{Initial: base, Max: base}
+	varied := false
+	first := b.NextDelay()
+	for i := 0; i < 200; i++ {
+		delay := b.NextDelay()
+		if jitter := delay - base; jitter < 0 || jitter >= base/10 {
+			t.Fatalf("jitter = %v, want in [0, %v)", jitter, base/10)
+		}
+		if delay != first {
+			varied = true
+		}
+	}
+	if !varied {
+		t.Fatalf("200 delays were all %v, want random jitter", first)
+	}
+}
+
+func TestBackoffPolicyReset(t *testing.T) {
+	b := &BackoffPolicy{Initial: time.Second, Max: time.Minute}
+	for i := 0; i < 4; i++ {
+		b.NextDelay()
+	}
+	if got := b.NextDelay(); !withJitter(got, 16*time.Second) {
+		t.Fatalf("fifth delay = %v, want 16s", got)
+	}
+	b.Reset()
+	if got := b.NextDelay(); !withJitter(got, time.Second) {
+		t.Fatalf("delay after Reset = %v, want 1s", got)
+	}
+}
+
+func TestWaitForRedis(t *testing.T) {
+	refused := errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+	tests := []struct {
+		name      string
+		pingErr   error
+		retries   int
+		wantErr   string
+		wantPings int
+	}{
+		{name: "available", retries: 3, wantPings: 1},
+		{name: "unavailable", pingErr: refused, retries: 3, wantErr: "after 3 attempts", wantPings: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := testutil.NewFakeRedisClient(0)
+			if tt.pingErr != nil {
+				rdb.ForceError("ping", "", tt.pingErr)
+			}
+			err := waitForRedis(context.Background(), rdb, tt.retries, &BackoffPolicy{Initial: time.Millisecond, Max: 2 * time.Millisecond})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("waitForRedis() = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("waitForRedis() = %v, want error containing %q", err, tt.wantErr)
+			}
+			if pings := len(rdb.Calls()); pings != tt.wantPings {
+				t.Fatalf("got %d PINGs, want %d", pings, tt.wantPings)
+			}
+		})
+	}
+}
+
+func TestWaitForRedisCancelled(t *testing.T) {
+	rdb := testutil.NewFakeRedisClient(0)
+	rdb.ForceError("ping", "", errors.New("connection refused"))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := waitForRedis(ctx, rdb, 10, &BackoffPolicy{Initial: time.Hour, Max: time.Hour})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waitForRedis() = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("waitForRedis() returned after %v, want it to stop waiting when ctx is done", elapsed)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 开启 --track-re-sets 或 --key-value-export-before-expiry 时需要同时订阅 set 事件
+func needsSetEvents(cfg *Config) bool {
+	return cfg.TrackReSets || cfg.ExportBeforeExpiry
+}
+
+// setEventPatterns 返回与 patterns 中的过期事件频道对应的 set 事件频道 (--track-re-sets)，
+// 例如 __keyevent@*__:expired -> __keyevent@*__:set
+func setEventPatterns(patterns []string) []string {
+	var sets []string
+	for _, p := range patterns {
+		if strings.HasSuffix(p, ":expired") {
+			sets = append(sets, strings.TrimSuffix(p, "expired")+"set")
+		}
+	}
+	return sets
+}
+
+// enableSetNotifications 确保 notify-keyspace-events 包含字符串命令事件 ($)，否则收不到 set 事件
+func enableSetNotifications(ctx context.Context, rdb *redis.Client) {
+	values, err := rdb.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil || len(values) < 2 {
+		log.Fatalf("Failed to get notify-keyspace-events configuration: %v", err)
+	}
+	value, _ := values[1].(string)
+	if strings.ContainsAny(value, "$A") {
+		return
+	}
+	if err := rdb.ConfigSet(ctx, "notify-keyspace-events", value+"$").Err(); err != nil {
+		log.Fatalf("Failed to set configuration: %v", err)
+	}
+	log.Printf("Configured notify-keyspace-events to '%s$' to receive set events", value)
+}
+
+// 键在清理之前被重新 SET，说明它已是一个新的键，从待清理的文件中删除旧记录
+func (h *eventHandler) handleReSet(ev ExpiredEvent) {
+	db, ok := parseChannelDB(ev.Channel)
+	if !ok {
+		db = h.cfg.DB
+	}
+	store := h.store
+	if h.dbStore != nil {
+		if store = h.dbStore.Store(db); store == nil {
+			return
+		}
+	}
+	removed, err := store.Remove(ev.Key)
+	if err != nil {
+		log.Printf("Failed to remove re-set key %s from %s: %v", ev.Key, store.Path(), err)
+		return
+	}
+	if removed {
+		debugf("Key %s was set again before cleanup, removed it from %s", ev.Key, store.Path())
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// nextOccurrence 返回 t 之后下一次到达本地时间 hhmm (HH:MM) 的时刻。
+// 使用 time.Date 按日历计算，跨越夏令时切换时仍然落在目标的本地时间上。
+// 夏令时开始当天不存在的时间 (如 02:30) 顺延跳过的时长 (03:30)，
+// 夏令时结束当天重复出现的时间只取第一次
+func nextOccurrence(t time.Time, hhmm string) (time.Time, error) {
+	clock, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected HH:MM", hhmm)
+	}
+
+	next := wallClock(t.Year(), t.Month(), t.Day(), clock, t.Location())
+	if !next.After(t) {
+		next = wallClock(t.Year(), t.Month(), t.Day()+1, clock, t.Location())
+	}
+	return next, nil
+}
+
+// 返回指定日期 clock 时刻的本地时间。time.Date 对不存在的时间按切换后的时区偏移计算，
+// 结果会早于跳过的区间，这里把它移到区间之后
+func wallClock(year int, month time.Month, day int, clock time.Time, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, clock.Hour(), clock.Minute(), 0, 0, loc)
+	// 在 UTC 中比较日历时间，跳过的区间跨越零点时也能得到正确的差值
+	want := time.Date(year, month, day, clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+	got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	if got.Before(want) {
+		t = t.Add(want.Sub(got))
+	}
+	return t
+}
+
+// randomJitter 返回 [0, jitter) 之间的随机时长，jitter 不大于 0 时返回 0。
+// 每次清理都重新随机，多个同时部署的实例不会总在同一时刻开始清理
+func randomJitter(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(jitter.Nanoseconds()))
+}
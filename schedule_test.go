@@ -0,0 +1,200 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // 测试环境不一定安装了时区数据库
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation(%q): %v", name, err)
+	}
+	return loc
+}
+
+func TestNextOccurrence(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		hhmm string
+		want time.Time
+	}{
+		{
+			name: "later today",
+			now:  time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC),
+			hhmm: "03:00",
+			want: time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "already passed today",
+			now:  time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC),
+			hhmm: "03:00",
+			want: time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			// 正好在目标时刻时已经执行过，取下一天
+			name: "exactly now",
+			now:  time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC),
+			hhmm: "03:00",
+			want: time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "midnight",
+			now:  time.Date(2024, 6, 1, 0, 0, 1, 0, time.UTC),
+			hhmm: "00:00",
+			want: time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "single digit hour",
+			now:  time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC),
+			hhmm: "3:05",
+			want: time.Date(2024, 6, 1, 3, 5, 0, 0, time.UTC),
+		},
+		{
+			name: "end of year",
+			now:  time.Date(2024, 12, 31, 23, 30, 0, 0, time.UTC),
+			hhmm: "00:15",
+			want: time.Date(2025, 1, 1, 0, 15, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			now:  time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC),
+			hhmm: "06:00",
+			want: time.Date(2024, 2, 29, 6, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextOccurrence(tt.now, tt.hhmm)
+			if err != nil {
+				t.Fatalf("nextOccurrence(%v, %q): %v", tt.now, tt.hhmm, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("nextOccurrence(%v, %q) = %v, want %v", tt.now, tt.hhmm, got, tt.want)
+			}
+		})
+	}
+}
+
+// 夏令时切换前后按本地时间计算，两次清理之间的实际间隔不再是 24 小时
+func TestNextOccurrenceDST(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")   // 2024-03-10 02:00 -> 03:00，2024-11-03 02:00 -> 01:00
+	havana := mustLoadLocation(t, "America/Havana") // 2024-03-10 00:00 -> 01:00
+	tests := []struct {
+		name      string
+		now       time.Time
+		hhmm      string
+		want      time.Time
+		wantDelay time.Duration
+	}{
+		{
+			name:      "spring forward normal time",
+			now:       time.Date(2024, 3, 9, 23, 0, 0, 0, ny),
+			hhmm:      "03:00",
+			want:      time.Date(2024, 3, 10, 3, 0, 0, 0, ny),
+			wantDelay: 3 * time.Hour,
+		},
+		{
+			name:      "spring forward daily interval",
+			now:       time.Date(2024, 3, 9, 3, 0, 0, 0, ny),
+			hhmm:      "03:00",
+			want:      time.Date(2024, 3, 10, 3, 0, 0, 0, ny),
+			wantDelay: 23 * time.Hour,
+		},
+		{
+			// 02:30 当天不存在，顺延到跳过的一小时之后
+			name:      "spring forward skipped time",
+			now:       time.Date(2024, 3, 10, 0, 0, 0, 0, ny),
+			hhmm:      "02:30",
+			want:      time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC), // 03:30 EDT
+			wantDelay: 150 * time.Minute,
+		},
+		{
+			name:      "after skipped time",
+			now:       time.Date(2024, 3, 10, 4, 0, 0, 0, ny),
+			hhmm:      "02:30",
+			want:      time.Date(2024, 3, 11, 2, 30, 0, 0, ny),
+			wantDelay: 22*time.Hour + 30*time.Minute,
+		},
+		{
+			// 跳过的区间从零点开始，time.Date 会把 00:30 算到前一天
+			name:      "skipped midnight",
+			now:       time.Date(2024, 3, 9, 12, 0, 0, 0, havana),
+			hhmm:      "00:30",
+			want:      time.Date(2024, 3, 10, 5, 30, 0, 0, time.UTC), // 01:30 CDT
+			wantDelay: 12*time.Hour + 30*time.Minute,
+		},
+		{
+			name:      "fall back normal time",
+			now:       time.Date(2024, 11, 2, 23, 0, 0, 0, ny),
+			hhmm:      "03:00",
+			want:      time.Date(2024, 11, 3, 3, 0, 0, 0, ny),
+			wantDelay: 5 * time.Hour,
+		},
+		{
+			name:      "fall back daily interval",
+			now:       time.Date(2024, 11, 2, 3, 0, 0, 0, ny),
+			hhmm:      "03:00",
+			want:      time.Date(2024, 11, 3, 3, 0, 0, 0, ny),
+			wantDelay: 25 * time.Hour,
+		},
+		{
+			// 01:30 出现两次，取第一次 (EDT)
+			name:      "fall back repeated time",
+			now:       time.Date(2024, 11, 3, 0, 0, 0, 0, ny),
+			hhmm:      "01:30",
+			want:      time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+			wantDelay: 90 * time.Minute,
+		},
+		{
+			// 第一次 01:30 之后不会在第二次 01:30 (EST) 再执行一次
+			name:      "after first repeated time",
+			now:       time.Date(2024, 11, 3, 5, 45, 0, 0, time.UTC).In(ny), // 01:45 EDT
+			hhmm:      "01:30",
+			want:      time.Date(2024, 11, 4, 1, 30, 0, 0, ny),
+			wantDelay: 24*time.Hour + 45*time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextOccurrence(tt.now, tt.hhmm)
+			if err != nil {
+				t.Fatalf("nextOccurrence(%v, %q): %v", tt.now, tt.hhmm, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("nextOccurrence(%v, %q) = %v, want %v", tt.now, tt.hhmm, got, tt.want.In(tt.now.Location()))
+			}
+			if delay := got.Sub(tt.now); delay != tt.wantDelay {
+				t.Errorf("delay = %v, want %v", delay, tt.wantDelay)
+			}
+			if got.Location() != tt.now.Location() {
+				t.Errorf("location = %v, want %v", got.Location(), tt.now.Location())
+			}
+		})
+	}
+}
+
+func TestNextOccurrenceInvalid(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, hhmm := range []string{"", "24:00", "03:60", "3am", "03:00:00", "-1:00"} {
+		if got, err := nextOccurrence(now, hhmm); err == nil {
+			t.Errorf("nextOccurrence(%q) = %v, want error", hhmm, got)
+		}
+	}
+}
+
+func TestRandomJitter(t *testing.T) {
+	for _, jitter := range []time.Duration{0, -time.Second} {
+		if got := randomJitter(jitter); got != 0 {
+			t.Errorf("randomJitter(%v) = %v, want 0", jitter, got)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if got := randomJitter(time.Second); got < 0 || got >= time.Second {
+			t.Fatalf("randomJitter(1s) = %v, want in [0, 1s)", got)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// 解析 --key-rotation-signal，SIGHUP 已用于重新打开审计日志和过滤文件，不能使用
+func parseRotationSignal(name string) (os.Signal, error) {
+	switch name {
+	case "SIGUSR1", "USR1":
+		return syscall.SIGUSR1, nil
+	case "SIGUSR2", "USR2":
+		return syscall.SIGUSR2, nil
+	}
+	return nil, fmt.Errorf("unsupported signal %q (expected SIGUSR1 or SIGUSR2)", name)
+}
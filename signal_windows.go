@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Windows 下没有 SIGUSR1 / SIGUSR2，不支持 --key-rotation-signal
+func parseRotationSignal(name string) (os.Signal, error) {
+	return nil, errors.New("signals other than interrupt are not supported on Windows")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// EventSink 是过期事件在过期键文件之外的输出目标
+type EventSink interface {
+	Write(ctx context.Context, rec KeyRecord) error
+}
+
+// RedisStreamSink 将每个过期事件追加到 Redis Stream (--stream-sink)，例如
+//
+//	XADD expired_events_stream * key mykey db 0 ts 1234567890
+//
+// 其他服务 (或另一个以 --use-stream 运行的实例，见 RedisStreamSource) 可以按消息 ID 从任意位置读取
+type RedisStreamSink struct {
+	rdb    *redis.Client
+	stream string
+	maxLen int64 // 大于 0 时以 MAXLEN ~ 限制 Stream 长度
+}
+
+func (s *RedisStreamSink) Write(ctx context.Context, rec KeyRecord) error {
+	ts := time.Now().Unix()
+	if t, err := time.Parse(time.RFC3339Nano, rec.TS); err == nil {
+		ts = t.Unix()
+	}
+	return s.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: s.maxLen > 0,
+		Values: []interface{}{"key", rec.Key, "db", rec.DB, "ts", ts},
+	}).Err()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// /events：以 Server-Sent Events 格式推送过期事件，可直接用 EventSource 或 curl -N 查看。
+// 每个事件带有序号 id，重连时通过 Last-Event-ID 补发最近缓冲的事件
+func sseEventsHandler(broker *eventBroker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		var lastID uint64
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+			lastID = id
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no") // 关闭反向代理的缓冲
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ch, missed := broker.SubscribeFrom(lastID, 1000)
+		defer broker.Unsubscribe(ch)
+
+		for _, ev := range missed {
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case ev := <-ch:
+				if err := writeSSE(w, ev); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, ev brokerEvent) error {
+	data, err := json.Marshal(ev.Record)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", ev.ID, data)
+	return err
+}
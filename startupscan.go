@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 启动扫描每扫描多少个键输出一次进度
+const startupScanProgressEvery = 10000
+
+// startupScan 在订阅之前 SCAN 整个数据库 (--startup-scan)，把已过期但尚未被清除的键写入过期键文件，
+// 使首次部署时已经积压的过期键也能被清理。pubsub 只能捕获订阅之后的过期事件
+func (c *Cleaner) startupScan(ctx context.Context) error {
+	db := c.rdb.Options().DB
+	log.Printf("Scanning database %d for expired keys before subscribing", db)
+
+	var scanned, written int
+	nextReport := startupScanProgressEvery
+	var cursor uint64
+	for {
+		keys, next, err := c.rdb.Scan(ctx, cursor, "*", 1000).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			cmds, err := c.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.TTL(ctx, key)
+				}
+				return nil
+			})
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			for i, cmd := range cmds {
+				if !scanCandidate(cmd.(*redis.DurationCmd).Val(), c.cfg.SkipKeysWithNoTTL) || !c.filter.Allow(keys[i]) {
+					continue
+				}
+				rec := KeyRecord{Key: keys[i], DB: db, TS: time.Now().Format(time.RFC3339Nano)}
+				if c.cfg.CaptureExpiryTime {
+					rec.ExpireMethod = expireMethodScan
+				}
+				if err := c.store.Append(rec); err != nil {
+					return err
+				}
+				written++
+			}
+		}
+
+		scanned += len(keys)
+		if scanned >= nextReport {
+			log.Printf("Startup scan of database %d: scanned %d keys, found %d expired", db, scanned, written)
+			nextReport += startupScanProgressEvery
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	log.Printf("Startup scan of database %d finished: scanned %d keys, wrote %d expired keys to %s", db, scanned, written, c.store.Path())
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/RESIDUALWASTE/RedisExpireKeysDelete/internal/humanize"
+)
+
+// 开启 --human-readable-stats 时，统计摘要中的数量和时长使用易读的格式 (1.2M、1h 1m 1s)
+var humanReadableStats bool
+
+func formatCount(n int) string {
+	if humanReadableStats {
+		return humanize.HumanizeInt(int64(n))
+	}
+	return strconv.Itoa(n)
+}
+
+func formatDuration(d time.Duration) string {
+	if humanReadableStats {
+		return humanize.HumanizeDuration(d)
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// cleanupStats 统计单次惰性删除的结果
+type cleanupStats struct {
+	start     time.Time
+	processed int
+	deleted   int
+	present   int
+	errors    int
+	encodings map[string]int // 键编码 -> 数量，仅在 --inspect-encoding 时统计
+	shared    int            // 引用计数大于 1 的键数，仅在 --inspect-refcount 时统计
+}
+
+func newCleanupStats() *cleanupStats {
+	return &cleanupStats{start: time.Now(), encodings: make(map[string]int)}
+}
+
+// 按处理结果计数
+func (s *cleanupStats) record(outcome string) {
+	s.processed++
+	switch outcome {
+	case outcomeDeleted:
+		s.deleted++
+	case outcomePresent:
+		s.present++
+	case outcomeError:
+		s.errors++
+	}
+}
+
+// 合并另一份统计 (例如并行分片的统计)，开始时间保留较早的一个
+func (s *cleanupStats) merge(other *cleanupStats) {
+	if other.start.Before(s.start) {
+		s.start = other.start
+	}
+	s.processed += other.processed
+	s.deleted += other.deleted
+	s.present += other.present
+	s.errors += other.errors
+	s.shared += other.shared
+	for encoding, n := range other.encodings {
+		s.encodings[encoding] += n
+	}
+}
+
+func (s *cleanupStats) recordEncoding(encoding string) {
+	s.encodings[encoding]++
+}
+
+// String 返回统计摘要，例如
+// processed=10 deleted=8 present=1 errors=1 duration=3.2s shared=2 encodings=[listpack=6 none=4]
+func (s *cleanupStats) String() string {
+	summary := fmt.Sprintf("processed=%s deleted=%s present=%s errors=%s duration=%s",
+		formatCount(s.processed), formatCount(s.deleted), formatCount(s.present), formatCount(s.errors),
+		formatDuration(time.Since(s.start)))
+	if s.shared > 0 {
+		summary += " shared=" + formatCount(s.shared)
+	}
+	if len(s.encodings) == 0 {
+		return summary
+	}
+
+	names := make([]string, 0, len(s.encodings))
+	for name := range s.encodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+formatCount(s.encodings[name]))
+	}
+	return summary + " encodings=[" + strings.Join(parts, " ") + "]"
+}
+
+// cleanupProgress 记录单次清理的进度，处理键的 goroutine 原子地累加计数，
+// 定期输出进度时无需加锁
+type cleanupProgress struct {
+	start     time.Time
+	total     int64
+	processed atomic.Int64
+}
+
+func newCleanupProgress(total int) *cleanupProgress {
+	return &cleanupProgress{start: time.Now(), total: int64(total)}
+}
+
+// add 累加已处理的键数，p 为 nil 时不做任何事
+func (p *cleanupProgress) add(n int) {
+	if p != nil {
+		p.processed.Add(int64(n))
+	}
+}
+
+type progressReport struct {
+	KeysProcessed int64   `json:"keys_processed"`
+	KeysRemaining int64   `json:"keys_remaining"`
+	RatePerSec    float64 `json:"rate_per_sec"`
+	ETASec        int64   `json:"eta_sec"`
+	ElapsedSec    int64   `json:"elapsed_sec"`
+}
+
+func (p *cleanupProgress) report() progressReport {
+	elapsed := time.Since(p.start)
+	processed := p.processed.Load()
+	r := progressReport{
+		KeysProcessed: processed,
+		KeysRemaining: max(p.total-processed, 0),
+		ElapsedSec:    int64(elapsed.Seconds()),
+	}
+	if elapsed > 0 {
+		r.RatePerSec = float64(processed) / elapsed.Seconds()
+	}
+	if r.RatePerSec > 0 {
+		r.ETASec = int64(float64(r.KeysRemaining) / r.RatePerSec)
+	}
+	return r
+}
+
+// Run 每隔 interval 输出一次进度，直到 ctx 结束
+func (p *cleanupProgress) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := json.Marshal(p.report())
+			if err != nil {
+				log.Printf("Failed to encode cleanup progress: %v", err)
+				continue
+			}
+			log.Printf("Cleanup progress: %s", data)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// --status-file 的默认路径
+const defaultStatusFile = ".cleanup_status.json"
+
+// 定期更新 current_backlog_size 的间隔
+const statusRefreshInterval = 30 * time.Second
+
+// cleanupStatus 是 --status-file 的内容，供 status 子命令读取
+type cleanupStatus struct {
+	LastCleanupTime       string `json:"last_cleanup_time,omitempty"`
+	LastCleanupDurationMS int64  `json:"last_cleanup_duration_ms"`
+	KeysProcessedLastRun  int    `json:"keys_processed_last_run"`
+	CurrentBacklogSize    int64  `json:"current_backlog_size"`
+	IsCurrentlyRunning    bool   `json:"is_currently_running"`
+	NextScheduledRun      string `json:"next_scheduled_run,omitempty"`
+	UpdatedAt             string `json:"updated_at"`
+}
+
+// StatusStore 在内存中维护清理状态，每次变化后先写临时文件再重命名，
+// 读取方 (status 子命令，可以有多个) 总是看到完整的文件
+type StatusStore struct {
+	mu      sync.Mutex
+	path    string
+	status  cleanupStatus
+	running int // 正在清理的 Cleaner 数，按数据库分文件时可能有多个
+}
+
+// NewStatusStore 创建写入 path 的 StatusStore
+func NewStatusStore(path string) *StatusStore {
+	return &StatusStore{path: path}
+}
+
+// CleanupStarted 标记一次清理开始，s 为 nil 时不做任何事
+func (s *StatusStore) CleanupStarted() {
+	if s == nil {
+		return
+	}
+	s.update(func(st *cleanupStatus) {
+		s.running++
+		st.IsCurrentlyRunning = true
+	})
+}
+
+// CleanupFinished 记录一次清理的结果
+func (s *StatusStore) CleanupFinished(start time.Time, processed int) {
+	if s == nil {
+		return
+	}
+	s.update(func(st *cleanupStatus) {
+		s.running--
+		st.IsCurrentlyRunning = s.running > 0
+		st.LastCleanupTime = start.Format(time.RFC3339)
+		st.LastCleanupDurationMS = time.Since(start).Milliseconds()
+		st.KeysProcessedLastRun = processed
+	})
+}
+
+// SetNextRun 记录下一次计划清理的时间，多个 Cleaner 时保留最早的一个
+func (s *StatusStore) SetNextRun(next time.Time) {
+	if s == nil {
+		return
+	}
+	s.update(func(st *cleanupStatus) {
+		if current, err := time.Parse(time.RFC3339, st.NextScheduledRun); err == nil && current.After(time.Now()) && current.Before(next) {
+			return
+		}
+		st.NextScheduledRun = next.Format(time.RFC3339)
+	})
+}
+
+// Run 每隔 30s 用 backlog 的返回值更新 current_backlog_size，直到 ctx 结束
+func (s *StatusStore) Run(ctx context.Context, backlog func() int64) {
+	ticker := time.NewTicker(statusRefreshInterval)
+	defer ticker.Stop()
+	for {
+		size := backlog()
+		s.update(func(st *cleanupStatus) { st.CurrentBacklogSize = size })
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 修改状态并写入文件，写入失败只打印日志
+func (s *StatusStore) update(fn func(*cleanupStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+	s.status.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	if err := s.write(); err != nil {
+		log.Printf("Failed to write status file: %v", err)
+	}
+}
+
+func (s *StatusStore) write() error {
+	data, err := json.MarshalIndent(s.status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// status 子命令：读取 --status-file 并输出
+//
+//	RedisExpireKeysDelete status --status-file=.cleanup_status.json
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	path := fs.String("status-file", defaultStatusFile, "Status file written by the running tool")
+	fs.Parse(args)
+
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		return err
+	}
+	var st cleanupStatus
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", *path, err)
+	}
+
+	orNever := func(s string) string {
+		if s == "" {
+			return "never"
+		}
+		return s
+	}
+	fmt.Printf("Last cleanup:          %s\n", orNever(st.LastCleanupTime))
+	fmt.Printf("Last cleanup duration: %s\n", formatDuration(time.Duration(st.LastCleanupDurationMS)*time.Millisecond))
+	fmt.Printf("Keys processed:        %s\n", formatCount(st.KeysProcessedLastRun))
+	fmt.Printf("Current backlog:       %s keys\n", formatCount(int(st.CurrentBacklogSize)))
+	fmt.Printf("Running:               %v\n", st.IsCurrentlyRunning)
+	fmt.Printf("Next scheduled run:    %s\n", orNever(st.NextScheduledRun))
+	fmt.Printf("Updated at:            %s\n", st.UpdatedAt)
+	return nil
+}
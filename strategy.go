@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 单个键的处理结果
+const (
+	outcomeDeleted = "deleted" // 键已不存在
+	outcomePresent = "present" // 键仍然存在 (可能已被重新创建)
+	outcomeError   = "error"
+	outcomeSkipped = "skipped" // noop 策略不访问 Redis
+)
+
+// 删除策略名称 (--deletion-strategy)
+const (
+	strategyType   = "type"
+	strategyExists = "exists"
+	strategyDel    = "del"
+	strategyUnlink = "unlink"
+	strategyScript = "script"
+	strategyNoop   = "noop"
+)
+
+// DeletionStrategy 决定清理时如何处理过期键文件中的每个键
+type DeletionStrategy interface {
+	Execute(ctx context.Context, rdb RedisClient, key string) (string, error)
+}
+
+// batchStrategy 是可以在 --transaction-batch 的 MULTI/EXEC 事务中执行的删除策略。
+// Queue 将处理 key 的命令加入事务，返回的函数在事务执行后给出该键的处理结果
+type batchStrategy interface {
+	Queue(ctx context.Context, pipe redis.Pipeliner, key string) func() (string, error)
+}
+
+// newDeletionStrategy 根据名称创建删除策略
+func newDeletionStrategy(name string) (DeletionStrategy, error) {
+	switch name {
+	case strategyType:
+		return typeStrategy{}, nil
+	case strategyExists:
+		return existsStrategy{}, nil
+	case strategyDel:
+		return delStrategy{}, nil
+	case strategyUnlink:
+		return unlinkStrategy{}, nil
+	case strategyScript:
+		return scriptStrategy{}, nil
+	case strategyNoop:
+		return noopStrategy{}, nil
+	}
+	return nil, fmt.Errorf("unknown deletion strategy %q (expected type, exists, del, unlink, script or noop)", name)
+}
+
+// typeStrategy 调用 TYPE 访问键，由 Redis 惰性删除已过期的键 (默认)
+type typeStrategy struct{}
+
+func (typeStrategy) Execute(ctx context.Context, rdb RedisClient, key string) (string, error) {
+	return typeOutcome(rdb.Type(ctx, key))
+}
+
+func (typeStrategy) Queue(ctx context.Context, pipe redis.Pipeliner, key string) func() (string, error) {
+	cmd := pipe.Type(ctx, key)
+	return func() (string, error) { return typeOutcome(cmd) }
+}
+
+func typeOutcome(cmd *redis.StatusCmd) (string, error) {
+	keyType, err := cmd.Result()
+	if err != nil {
+		return outcomeError, err
+	}
+	// TYPE 返回 none 说明键已被惰性删除，否则键仍然存在 (可能已被重新创建)
+	if keyType != "none" {
+		return outcomePresent, nil
+	}
+	return outcomeDeleted, nil
+}
+
+// existsStrategy 调用 EXISTS 访问键，同样会触发惰性删除
+type existsStrategy struct{}
+
+func (existsStrategy) Execute(ctx context.Context, rdb RedisClient, key string) (string, error) {
+	return existsOutcome(rdb.Exists(ctx, key))
+}
+
+func (existsStrategy) Queue(ctx context.Context, pipe redis.Pipeliner, key string) func() (string, error) {
+	cmd := pipe.Exists(ctx, key)
+	return func() (string, error) { return existsOutcome(cmd) }
+}
+
+func existsOutcome(cmd *redis.IntCmd) (string, error) {
+	n, err := cmd.Result()
+	if err != nil {
+		return outcomeError, err
+	}
+	if n > 0 {
+		return outcomePresent, nil
+	}
+	return outcomeDeleted, nil
+}
+
+// delStrategy 直接 DEL，即使键已被重新创建也会删除
+type delStrategy struct{}
+
+func (delStrategy) Execute(ctx context.Context, rdb RedisClient, key string) (string, error) {
+	return deleteOutcome(rdb.Del(ctx, key))
+}
+
+func (delStrategy) Queue(ctx context.Context, pipe redis.Pipeliner, key string) func() (string, error) {
+	cmd := pipe.Del(ctx, key)
+	return func() (string, error) { return deleteOutcome(cmd) }
+}
+
+func deleteOutcome(cmd *redis.IntCmd) (string, error) {
+	if err := cmd.Err(); err != nil {
+		return outcomeError, err
+	}
+	return outcomeDeleted, nil
+}
+
+// unlinkStrategy 与 del 相同，但由 Redis 在后台线程释放内存
+type unlinkStrategy struct{}
+
+func (unlinkStrategy) Execute(ctx context.Context, rdb RedisClient, key string) (string, error) {
+	return deleteOutcome(rdb.Unlink(ctx, key))
+}
+
+func (unlinkStrategy) Queue(ctx context.Context, pipe redis.Pipeliner, key string) func() (string, error) {
+	cmd := pipe.Unlink(ctx, key)
+	return func() (string, error) { return deleteOutcome(cmd) }
+}
+
+// scriptStrategy 用 Lua 脚本在服务端原子地检查并删除。脚本被清除时重新加载，
+// 重新加载后仍不在缓存中时该键退回 TYPE
+type scriptStrategy struct{}
+
+func (scriptStrategy) Execute(ctx context.Context, rdb RedisClient, key string) (string, error) {
+	deleted, err := atomicConditionalDelete(ctx, rdb, key)
+	if isNoScript(err) {
+		deduplicateLog("", "WARN: Conditional delete script is still not cached after SCRIPT LOAD, falling back to TYPE for key %s", key)
+		return typeStrategy{}.Execute(ctx, rdb, key)
+	}
+	if err != nil {
+		return outcomeError, err
+	}
+	if deleted {
+		return outcomeDeleted, nil
+	}
+	return outcomePresent, nil
+}
+
+// 事务中的 EVALSHA 遇到 NOSCRIPT 时无法在同一个事务内重新加载，因此直接用 EVAL 发送脚本
+func (scriptStrategy) Queue(ctx context.Context, pipe redis.Pipeliner, key string) func() (string, error) {
+	cmd := pipe.Eval(ctx, conditionalDeleteScript, []string{key})
+	return func() (string, error) {
+		n, err := cmd.Int64()
+		if err != nil {
+			return outcomeError, err
+		}
+		if n == 1 {
+			return outcomeDeleted, nil
+		}
+		return outcomePresent, nil
+	}
+}
+
+// noopStrategy 不访问 Redis，用于演练
+type noopStrategy struct{}
+
+func (noopStrategy) Execute(ctx context.Context, rdb RedisClient, key string) (string, error) {
+	return outcomeSkipped, nil
+}
+
+func (noopStrategy) Queue(ctx context.Context, pipe redis.Pipeliner, key string) func() (string, error) {
+	return func() (string, error) { return outcomeSkipped, nil }
+}
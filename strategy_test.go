@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/RESIDUALWASTE/RedisExpireKeysDelete/internal/testutil"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestDeletionStrategies(t *testing.T) {
+	noperm := errors.New("NOPERM no permissions")
+	noscript := errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	tests := []struct {
+		name        string
+		strategy    string
+		setup       func(f *testutil.FakeRedisClient)
+		wantOutcome string
+		wantErr     error
+		wantCalls   []string
+	}{
+		{
+			name:        "type expired key",
+			strategy:    strategyType,
+			wantOutcome: outcomeDeleted,
+			wantCalls:   []string{"type k"},
+		},
+		{
+			name:        "type re-created key",
+			strategy:    strategyType,
+			setup:       func(f *testutil.FakeRedisClient) { f.AddTypeResponse("k", "string") },
+			wantOutcome: outcomePresent,
+			wantCalls:   []string{"type k"},
+		},
+		{
+			name:        "type error",
+			strategy:    strategyType,
+			setup:       func(f *testutil.FakeRedisClient) { f.ForceError("type", "k", noperm) },
+			wantOutcome: outcomeError,
+			wantErr:     noperm,
+			wantCalls:   []string{"type k"},
+		},
+		{
+			name:        "exists expired key",
+			strategy:    strategyExists,
+			wantOutcome: outcomeDeleted,
+			wantCalls:   []string{"exists k"},
+		},
+		{
+			name:        "exists re-created key",
+			strategy:    strategyExists,
+			setup:       func(f *testutil.FakeRedisClient) { f.AddTypeResponse("k", "hash") },
+			wantOutcome: outcomePresent,
+			wantCalls:   []string{"exists k"},
+		},
+		{
+			name:        "del",
+			strategy:    strategyDel,
+			setup:       func(f *testutil.FakeRedisClient) { f.AddDelResponse("k", 1) },
+			wantOutcome: outcomeDeleted,
+			wantCalls:   []string{"del k"},
+		},
+		{
+			name:        "del error",
+			strategy:    strategyDel,
+			setup:       func(f *testutil.FakeRedisClient) { f.ForceError("del", "k", noperm) },
+			wantOutcome: outcomeError,
+			wantErr:     noperm,
+			wantCalls:   []string{"del k"},
+		},
+		{
+			name:        "unlink",
+			strategy:    strategyUnlink,
+			wantOutcome: outcomeDeleted,
+			wantCalls:   []string{"unlink k"},
+		},
+		{
+			name:        "script expired key",
+			strategy:    strategyScript,
+			wantOutcome: outcomeDeleted,
+			wantCalls:   []string{"evalsha k"},
+		},
+		{
+			name:        "script re-created key",
+			strategy:    strategyScript,
+			setup:       func(f *testutil.FakeRedisClient) { f.AddTypeResponse("k", "list") },
+			wantOutcome: outcomePresent,
+			wantCalls:   []string{"evalsha k"},
+		},
+		{
+			// 重新加载后仍然 NOSCRIPT 时退回 TYPE
+			name:        "script not cached",
+			strategy:    strategyScript,
+			setup:       func(f *testutil.FakeRedisClient) { f.ForceError("evalsha", "k", noscript) },
+			wantOutcome: outcomeDeleted,
+			wantCalls:   []string{"evalsha k", "script load ", "evalsha k", "type k"},
+		},
+		{
+			name:        "noop",
+			strategy:    strategyNoop,
+			wantOutcome: outcomeSkipped,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := newDeletionStrategy(tt.strategy)
+			if err != nil {
+				t.Fatalf("newDeletionStrategy(%q): %v", tt.strategy, err)
+			}
+			rdb := testutil.NewFakeRedisClient(0)
+			if tt.setup != nil {
+				tt.setup(rdb)
+			}
+
+			outcome, err := strategy.Execute(context.Background(), rdb, "k")
+			if err != tt.wantErr {
+				t.Errorf("Execute() error = %v, want %v", err, tt.wantErr)
+			}
+			if outcome != tt.wantOutcome {
+				t.Errorf("Execute() outcome = %q, want %q", outcome, tt.wantOutcome)
+			}
+			if calls := rdb.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNewDeletionStrategyUnknown(t *testing.T) {
+	if _, err := newDeletionStrategy("expire"); err == nil {
+		t.Fatal("newDeletionStrategy(\"expire\") succeeded, want error")
+	}
+}
+
+// --transaction-batch 的事务按 --deletion-strategy 处理每个键，而不是固定使用 TYPE
+func TestTouchBatchUsesStrategy(t *testing.T) {
+	tests := []struct {
+		strategy     string
+		wantOutcomes [2]string // live, gone
+		wantLive     bool
+	}{
+		{strategyType, [2]string{outcomePresent, outcomeDeleted}, true},
+		{strategyExists, [2]string{outcomePresent, outcomeDeleted}, true},
+		{strategyDel, [2]string{outcomeDeleted, outcomeDeleted}, false},
+		{strategyUnlink, [2]string{outcomeDeleted, outcomeDeleted}, false},
+		// live 没有 TTL，条件删除脚本不删除
+		{strategyScript, [2]string{outcomePresent, outcomeDeleted}, true},
+		{strategyNoop, [2]string{outcomeSkipped, outcomeSkipped}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { rdb.Close() })
+			mr.Set("session:live", "v")
+
+			c := newTestCleaner(t, rdb, tt.strategy)
+			audit, err := OpenAuditLog(c.cfg.KeyFile + ".audit")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer audit.Close()
+			c.audit = audit
+
+			if err := c.touchBatch(context.Background(), []string{"session:live", "session:gone"}, 0, c.strategy, newCleanupStats()); err != nil {
+				t.Fatalf("touchBatch() error = %v", err)
+			}
+			got := readAudit(t, audit.path)
+			if got["session:live"] != tt.wantOutcomes[0] || got["session:gone"] != tt.wantOutcomes[1] {
+				t.Errorf("outcomes = %v, want live %s, gone %s", got, tt.wantOutcomes[0], tt.wantOutcomes[1])
+			}
+			if live := mr.Exists("session:live"); live != tt.wantLive {
+				t.Errorf("session:live exists = %v after the transaction, want %v", live, tt.wantLive)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OpenTelemetry 的 tracer 名称和 span 名称
+const (
+	tracerName    = "github.com/RESIDUALWASTE/RedisExpireKeysDelete"
+	spanCleanup   = "cleanup"
+	spanKeyExpiry = "key_expired"
+)
+
+// 开启 --trace-file 时设置全局 TracerProvider，未开启时 otel.Tracer 返回的 tracer 不做任何事。
+//
+// 采样规则：
+//   - 清理 (cleanup span) 次数很少，总是采样
+//   - 每个过期事件 (key_expired span) 是单独的 trace，按 --trace-sample-rate 的比例根据 trace ID 采样，
+//     与清理的采样相互独立：一个键的事件被采样不代表清理它的那次清理会被关联到同一个 trace
+//   - 子 span 跟随父 span 的采样结果
+func setupTracing(path string, sampleRate float64) (func(context.Context) error, error) {
+	exporter, err := newJSONSpanExporter(path)
+	if err != nil {
+		return nil, err
+	}
+	sampler := sdktrace.ParentBased(cleanupSampler{events: sdktrace.TraceIDRatioBased(sampleRate)})
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithBatcher(exporter),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// cleanupSampler 总是采样清理的 span，其他 span 交给 events 采样
+type cleanupSampler struct {
+	events sdktrace.Sampler
+}
+
+func (s cleanupSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if p.Name == spanCleanup {
+		return sdktrace.AlwaysSample().ShouldSample(p)
+	}
+	return s.events.ShouldSample(p)
+}
+
+func (s cleanupSampler) Description() string {
+	return "CleanupAlwaysOn{" + s.events.Description() + "}"
+}
+
+// jsonSpanExporter 将结束的 span 以每行一个 JSON 对象的形式追加写入文件
+type jsonSpanExporter struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+type jsonSpan struct {
+	Name       string                 `json:"name"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_span_id,omitempty"`
+	Start      time.Time              `json:"start"`
+	DurationMS float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func newJSONSpanExporter(path string) (*jsonSpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonSpanExporter{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (e *jsonSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, span := range spans {
+		out := jsonSpan{
+			Name:       span.Name(),
+			TraceID:    span.SpanContext().TraceID().String(),
+			SpanID:     span.SpanContext().SpanID().String(),
+			Start:      span.StartTime(),
+			DurationMS: float64(span.EndTime().Sub(span.StartTime()).Microseconds()) / 1000,
+		}
+		if span.Parent().IsValid() {
+			out.ParentID = span.Parent().SpanID().String()
+		}
+		if attrs := span.Attributes(); len(attrs) > 0 {
+			out.Attributes = make(map[string]interface{}, len(attrs))
+			for _, kv := range attrs {
+				out.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+			}
+		}
+		if err := e.enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *jsonSpanExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --key-ttl-bucket 最多记录多少个键名的上次过期时间，超出后清空重新记录
+const maxTTLBucketKeys = 1 << 20
+
+// 解析 --ttl-buckets 中逗号分隔的分桶边界 (秒)，必须严格递增
+func parseTTLBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid bucket %q", part)
+		}
+		if len(buckets) > 0 && v <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be increasing, got %v after %v", v, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, v)
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no buckets")
+	}
+	return buckets, nil
+}
+
+// ttlBucketer 按键的 TTL 分桶统计过期事件 (--key-ttl-bucket)。
+// 过期事件中没有 TTL：有伴随键 <ttl-hint-prefix><key> 时使用其中的原始 TTL，
+// 否则用同名键两次过期之间的间隔近似 (适用于过期后被重新写入的缓存键)
+type ttlBucketer struct {
+	metrics *Metrics
+
+	mu   sync.Mutex
+	last map[string]time.Time // 键名 -> 上次过期时间
+}
+
+func newTTLBucketer(metrics *Metrics) *ttlBucketer {
+	return &ttlBucketer{metrics: metrics, last: make(map[string]time.Time)}
+}
+
+// Observe 记录一次过期事件，hint 是 eventHandler 已经读到的伴随键，t 为 nil 时不做任何事
+func (t *ttlBucketer) Observe(key string, hint ttlHint) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	previous, seen := t.last[key]
+	if len(t.last) >= maxTTLBucketKeys {
+		t.last = make(map[string]time.Time)
+	}
+	t.last[key] = now
+	t.mu.Unlock()
+
+	if hint.valid && hint.seconds > 0 {
+		t.metrics.Observe(metricKeyTTLBucket, float64(hint.seconds), map[string]string{"source": "hint"})
+		return
+	}
+	if seen {
+		t.metrics.Observe(metricKeyTTLBucket, now.Sub(previous).Seconds(), map[string]string{"source": "reexpiry"})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// typeHandler 描述匹配的键在清理时如何处理，目前只支持 hash：HDEL 指定的字段而不是删除整个键
+type typeHandler struct {
+	Type      string   `yaml:"type"`
+	DelFields []string `yaml:"del_fields"`
+}
+
+// typeHandlerRule 表示匹配 Pattern 的键使用 Handler 处理
+type typeHandlerRule struct {
+	Pattern string
+	Handler typeHandler
+}
+
+// 读取 --type-handler-config 文件，例如
+//
+//	"sessions:*":
+//	  type: hash
+//	  del_fields: ["session_data"]
+//
+// 规则按模式长度从长到短排序，键只使用匹配到的第一条规则
+func loadTypeHandlers(path string) ([]typeHandlerRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var handlers map[string]typeHandler
+	if err := yaml.Unmarshal(data, &handlers); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	rules := make([]typeHandlerRule, 0, len(handlers))
+	for pattern, handler := range handlers {
+		if err := validatePattern(pattern); err != nil {
+			return nil, err
+		}
+		if handler.Type != "hash" {
+			return nil, fmt.Errorf("unsupported type %q for %q (expected hash)", handler.Type, pattern)
+		}
+		if len(handler.DelFields) == 0 {
+			return nil, fmt.Errorf("no del_fields for %q", pattern)
+		}
+		rules = append(rules, typeHandlerRule{Pattern: pattern, Handler: handler})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].Pattern) != len(rules[j].Pattern) {
+			return len(rules[i].Pattern) > len(rules[j].Pattern)
+		}
+		return rules[i].Pattern < rules[j].Pattern
+	})
+	return rules, nil
+}
+
+// TypeAwareDeletion 对匹配 --type-handler-config 规则的键只删除指定的字段，
+// 其余键交给 fallback (--deletion-strategy) 处理
+type TypeAwareDeletion struct {
+	rules    []typeHandlerRule
+	fallback DeletionStrategy
+}
+
+func (s *TypeAwareDeletion) Execute(ctx context.Context, rdb RedisClient, key string) (string, error) {
+	for _, rule := range s.rules {
+		if !matchPattern(rule.Pattern, key) {
+			continue
+		}
+		if err := rdb.HDel(ctx, key, rule.Handler.DelFields...).Err(); err != nil {
+			return outcomeError, err
+		}
+		return outcomeDeleted, nil
+	}
+	return s.fallback.Execute(ctx, rdb, key)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-redis/redis/v8"
+)
+
+// 构建信息，由 make build-release 通过 -ldflags "-X main.Version=..." 写入
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildTime = "unknown"
+)
+
+// 输出 --version 的版本信息
+func printVersion() {
+	fmt.Printf("redis-expire-delete version %s (commit: %s, built: %s)\n", Version, GitCommit, BuildTime)
+}
+
+// keyspace 通知和 ACL 分别在这两个版本引入
+var (
+	minKeyspaceNotifyVersion = semver.MustParse("2.8.0")
+	minACLVersion            = semver.MustParse("6.0.0")
+)
+
+// parseRedisVersion 从 INFO server 的输出中解析 redis_version
+func parseRedisVersion(info string) (semver.Version, error) {
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "redis_version:") {
+			continue
+		}
+		v, err := semver.NewVersion(strings.TrimPrefix(line, "redis_version:"))
+		if err != nil {
+			return semver.Version{}, fmt.Errorf("invalid redis_version: %v", err)
+		}
+		return *v, nil
+	}
+	return semver.Version{}, fmt.Errorf("redis_version not found in INFO output")
+}
+
+// 检查 Redis 版本是否支持 keyspace 通知，低于 2.8 时直接退出
+func checkRedisVersion(ctx context.Context, rdb *redis.Client) {
+	info, err := rdb.Info(ctx, "server").Result()
+	if err != nil {
+		log.Fatalf("Failed to get server info: %v", err)
+	}
+	version, err := parseRedisVersion(info)
+	if err != nil {
+		log.Fatalf("Failed to parse Redis version: %v", err)
+	}
+
+	if version.LessThan(minKeyspaceNotifyVersion) {
+		log.Fatalf("Redis %s does not support keyspace notifications (requires 2.8+); use --compat-mode=scan instead", version.String())
+	}
+	if version.LessThan(minACLVersion) {
+		log.Printf("WARN: Redis %s does not support ACLs (requires 6.0+)", version.String())
+	}
+	debugf("Redis server version %s", version.String())
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// 根据 --allowed-origins 创建 Upgrader。列表为空时使用 gorilla/websocket 默认的同源检查，
+// 否则只允许列表中的 Origin，"*" 允许任意来源。没有 Origin 头的请求不是来自浏览器，始终允许
+func newUpgrader(allowedOrigins []string) *websocket.Upgrader {
+	if len(allowedOrigins) == 0 {
+		return &websocket.Upgrader{}
+	}
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			for _, allowed := range allowedOrigins {
+				if allowed == "*" || strings.EqualFold(allowed, origin) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// /ws/keys：每收到一个过期事件，向 WebSocket 客户端发送一条 JSON 消息
+// {"key":"...","db":0,"ts":"..."}，可用 ?pattern= 按 glob 模式过滤键名
+func wsKeysHandler(broker *eventBroker, allowedOrigins []string) http.HandlerFunc {
+	upgrader := newUpgrader(allowedOrigins)
+	return func(w http.ResponseWriter, r *http.Request) {
+		pattern := r.URL.Query().Get("pattern")
+		if pattern != "" {
+			if err := validatePattern(pattern); err != nil {
+				http.Error(w, "invalid pattern", http.StatusBadRequest)
+				return
+			}
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Failed to upgrade WebSocket connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		ch := broker.Subscribe(1000)
+		defer broker.Unsubscribe(ch)
+
+		// 读取客户端消息只为及时发现连接关闭
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case ev := <-ch:
+				if !matchPattern(pattern, ev.Record.Key) {
+					continue
+				}
+				if err := conn.WriteJSON(ev.Record); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+}